@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sakaino2/image-compressor/controllers"
+)
+
+const convertUsage = `Usage: image-compressor convert [flags] <file|glob|directory>...
+
+Converts the given images to WebP without opening a window. Directories are
+scanned for supported images, recursively with --recursive.
+
+Flags:
+`
+
+// runConvertCommand runs the headless "convert" subcommand and returns the
+// process exit code: 0 when every file converted, 1 when any file failed and
+// 2 for invalid usage.
+func runConvertCommand(args []string) int {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	quality := fs.Int("quality", 80, "WebP quality (1-100)")
+	lossless := fs.Bool("lossless", false, "use lossless encoding (--quality is ignored)")
+	targetSize := fs.String("target-size", "", "largest output size, e.g. 200KB; the highest quality that fits is used instead of --quality")
+	minSSIM := fs.Float64("min-ssim", 0, "pick the lowest quality whose SSIM to the source is at least this, e.g. 0.95 (0 = off)")
+	ifLarger := fs.String("if-larger", string(controllers.LargerWrite), "when the WebP is bigger than the original: write (and flag it), skip or copy (the original)")
+	exact := fs.Bool("exact", false, "keep RGB values under fully transparent pixels (lossless only)")
+	maxWidth := fs.Int("max-width", 0, "downscale to at most this many pixels wide (0 = no limit)")
+	maxHeight := fs.Int("max-height", 0, "downscale to at most this many pixels high (0 = no limit)")
+	resizeMode := fs.String("resize-mode", string(controllers.ResizeFit), "how to apply --max-width/--max-height: fit, fill or exact")
+	resampler := fs.String("resample", string(controllers.ResampleCatmullRom), "resampling kernel: nearest, bilinear, catmull-rom or lanczos")
+	metadata := fs.String("metadata", string(controllers.MetadataStrip), "metadata to keep: strip, keep or copyright (ICC profile plus author/copyright)")
+	preservePaths := fs.Bool("preserve-paths", false, "recreate each file's path relative to --source-root under --out-dir")
+	sourceRoot := fs.String("source-root", "", "root for --preserve-paths (default: the folder all inputs share)")
+	recursive := fs.Bool("recursive", false, "scan directories recursively")
+	include := fs.String("include", "", "comma-separated glob patterns; only matching files are taken from directories")
+	exclude := fs.String("exclude", "", "comma-separated glob patterns for files and directories to skip")
+	followSymlinks := fs.Bool("follow-symlinks", false, "follow symbolic links while scanning directories")
+	concurrency := fs.Int("concurrency", controllers.DefaultConcurrency(), "number of files converted at once")
+	outputDir := fs.String("out-dir", "", "output directory (default: next to originals)")
+	reportPath := fs.String("report", "", "write a per-file report to this .json or .csv file")
+	nameTemplate := fs.String("name-template", controllers.DefaultTemplate, "output file name; tokens: {name} {ext} {width} {height} {quality} {hash} {date} {time} {timestamp}")
+	incremental := fs.String("incremental", string(controllers.IncrementalOff), "skip up-to-date outputs: off, newer (output newer than source) or strict (same content and settings, tracked in a manifest)")
+	onCollision := fs.String("on-collision", string(controllers.CollisionOverwrite), "when the output exists: overwrite, skip, suffix or error")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), convertUsage)
+		fs.PrintDefaults()
+	}
+
+	// Allow flags to appear before, between or after the inputs
+	var inputs []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return 0
+			}
+			return 2
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		inputs = append(inputs, args[0])
+		args = args[1:]
+	}
+
+	// Remove temp files a killed earlier run left behind
+	controllers.CleanupRecordedTempFiles()
+
+	if !*lossless && (*quality < 1 || *quality > 100) {
+		fmt.Fprintln(os.Stderr, "Error: Quality must be between 1 and 100")
+		return 2
+	}
+
+	if *concurrency < 1 {
+		fmt.Fprintln(os.Stderr, "Error: Concurrency must be at least 1")
+		return 2
+	}
+
+	if *reportPath != "" {
+		if _, err := controllers.ReportFormatForPath(*reportPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+	}
+
+	if *outputDir != "" {
+		if info, err := os.Stat(*outputDir); err != nil || !info.IsDir() {
+			fmt.Fprintln(os.Stderr, "Error: Invalid output directory")
+			return 2
+		}
+	}
+
+	paths, warnings, err := expandInputs(inputs, controllers.ScanOptions{
+		Recursive:      *recursive,
+		Include:        controllers.SplitPatterns(*include),
+		Exclude:        controllers.SplitPatterns(*exclude),
+		FollowSymlinks: *followSymlinks,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %v; skipped\n", warning)
+	}
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: No files selected")
+		fs.Usage()
+		return 2
+	}
+
+	opts := controllers.DefaultOptions()
+	opts.Quality = float32(*quality)
+	opts.Lossless = *lossless
+	opts.Exact = *exact
+	opts.Metadata = controllers.MetadataPolicy(*metadata)
+	opts.Collision = controllers.CollisionPolicy(*onCollision)
+	opts.Incremental = controllers.IncrementalMode(*incremental)
+	opts.MinSSIM = *minSSIM
+	opts.Larger = controllers.LargerPolicy(*ifLarger)
+	if *targetSize != "" {
+		if opts.TargetSize, err = controllers.ParseSize(*targetSize); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+	}
+	opts.Resize = controllers.ResizeOptions{
+		MaxWidth:  *maxWidth,
+		MaxHeight: *maxHeight,
+		Mode:      controllers.ResizeMode(*resizeMode),
+		Resampler: controllers.Resampler(*resampler),
+	}
+	converter := controllers.NewConverter()
+
+	layout := controllers.OutputLayout{
+		Dir:              *outputDir,
+		PreserveRelative: *preservePaths,
+		SourceRoot:       *sourceRoot,
+		Template:         *nameTemplate,
+	}
+	if err := layout.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	if layout.PreserveRelative && layout.SourceRoot == "" {
+		layout.SourceRoot = controllers.CommonDir(paths)
+	}
+
+	if opts.Incremental == controllers.IncrementalStrict {
+		manifest, err := controllers.LoadManifest(controllers.ManifestPath(layout, paths))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		opts.Manifest = manifest
+	}
+	if err := opts.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	jobs := make([]controllers.Job, len(paths))
+	for i, path := range paths {
+		if warning := controllers.ExtensionMismatch(path); warning != "" {
+			fmt.Fprintf(os.Stderr, "⚠ %s: %s\n", path, warning)
+		}
+		outputDir, outputPath, err := layout.Path(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+		jobs[i] = controllers.Job{Index: i, InputPath: path, OutputDir: outputDir, OutputPath: outputPath}
+	}
+
+	// Stop the batch cleanly on Ctrl+C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Print each result as soon as its worker finishes
+	successCount, skippedCount := 0, 0
+	var total savings
+	entries := make([]controllers.ReportEntry, len(jobs))
+	for result := range converter.ConvertBatch(ctx, jobs, opts, controllers.BatchOptions{Concurrency: *concurrency}) {
+		entries[result.Index] = controllers.NewReportEntry(result.InputPath, result.Report, result.Err)
+		if errors.Is(result.Err, context.Canceled) {
+			continue
+		}
+		if result.Err != nil {
+			fmt.Printf("❌ %s: %v\n", result.InputPath, result.Err)
+			continue
+		}
+		if result.Report.Skipped {
+			skippedCount++
+		} else {
+			successCount++
+			total.add(result.Report)
+		}
+		fmt.Println(resultLine(result.InputPath, result.Report, opts))
+	}
+
+	// Keep what finished, even when cancelled
+	if opts.Manifest != nil {
+		if err := opts.Manifest.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	if *reportPath != "" {
+		if err := controllers.SaveReport(*reportPath, entries); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: writing report: %v\n", err)
+			return 1
+		}
+	}
+
+	if ctx.Err() != nil {
+		fmt.Printf("Cancelled! %d/%d files converted before cancelling\n", successCount, len(paths))
+		return 1
+	}
+
+	fmt.Printf("Complete! %d/%d files converted successfully", successCount, len(paths))
+	if skippedCount > 0 {
+		fmt.Printf(", %d skipped", skippedCount)
+	}
+	if successCount > 0 {
+		fmt.Printf(", %s", total)
+	}
+	fmt.Println()
+
+	if successCount+skippedCount != len(paths) {
+		return 1
+	}
+	return 0
+}
+
+// expandInputs resolves command-line inputs into a de-duplicated list of
+// files. Globs are expanded and directories are scanned with scan. Plain file
+// paths are passed through unchanged so that a missing file is reported as a
+// conversion failure. Subdirectories that cannot be read are skipped and
+// reported in warnings.
+func expandInputs(inputs []string, scan controllers.ScanOptions) (paths []string, warnings []error, err error) {
+	seen := make(map[string]bool)
+
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, input := range inputs {
+		if strings.ContainsAny(input, "*?[") {
+			matches, err := filepath.Glob(input)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid pattern %q: %w", input, err)
+			}
+			for _, match := range matches {
+				if info, err := os.Stat(match); err == nil && !info.IsDir() {
+					add(match)
+				}
+			}
+			continue
+		}
+
+		info, err := os.Stat(input)
+		if err != nil || !info.IsDir() {
+			add(input)
+			continue
+		}
+
+		files, skipped, err := controllers.ScanDirectory(input, scan)
+		if err != nil {
+			return nil, nil, err
+		}
+		warnings = append(warnings, skipped...)
+		for _, file := range files {
+			add(file)
+		}
+	}
+
+	return paths, warnings, nil
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"gioui.org/app"
+	"gioui.org/f32"
+	"gioui.org/io/event"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/Sakaino2/image-compressor/controllers"
+)
+
+// Values of compareView.mode
+const (
+	compareSideBySide = "side"
+	compareSplit      = "split"
+)
+
+const (
+	// previewPixels bounds the edge length of decoded previews, which must
+	// fit in a GPU texture.
+	previewPixels = 8192
+
+	// maxZoom is the largest magnification, 800%.
+	maxZoom = 8
+
+	// zoomStep is the magnification change of one scroll notch or zoom
+	// button press.
+	zoomStep = 1.25
+)
+
+// splitLineColor draws the split line over the images.
+var splitLineColor = color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
+
+// comparedImage is one side of a comparison.
+type comparedImage struct {
+	img paint.ImageOp
+
+	// size is the size of img, width and height those of the file
+	size          image.Point
+	width, height int
+	bytes         int64
+}
+
+// compareView shows the source of a file next to its WebP output, or both
+// overlaid with a split line. Pan and zoom are shared by both images. It
+// belongs to the UI goroutine.
+type compareView struct {
+	item    *FileItem
+	loading bool
+	err     error
+
+	before comparedImage
+	after  *comparedImage
+
+	mode       widget.Enum
+	closeBtn   widget.Clickable
+	prevBtn    widget.Clickable
+	nextBtn    widget.Clickable
+	zoomInBtn  widget.Clickable
+	zoomOutBtn widget.Clickable
+	fitBtn     widget.Clickable
+	actualBtn  widget.Clickable
+
+	// scale maps preview pixels to screen pixels. Zero fits the image to
+	// its pane, at the scale fit from the last frame.
+	scale float32
+	fit   float32
+
+	// center is the preview point shown at the centre of each pane
+	center f32.Point
+
+	// split is where the split line sits across the pane, from 0 to 1
+	split float32
+
+	// Pointer drag state
+	dragging  bool
+	dragSplit bool
+	last      f32.Point
+}
+
+// openCompare shows item in the comparison viewer, decoding its source and
+// output in the background.
+func (a *App) openCompare(w *app.Window, item *FileItem) {
+	v := &a.compare
+	v.item = item
+	v.loading = true
+	v.err = nil
+	v.before, v.after = comparedImage{}, nil
+	v.scale, v.split = 0, 0.5
+	v.dragging = false
+	if v.mode.Value == "" {
+		v.mode.Value = compareSideBySide
+	}
+
+	output := ""
+	if item.hasOutput() {
+		output = item.report.OutputPath
+	}
+	go func(path, output string) {
+		ev := compareLoadedEvent{item: item}
+		ev.before, ev.err = loadPreview(path)
+		if ev.err == nil && output != "" {
+			if after, err := loadPreview(output); err == nil {
+				ev.after = &after
+			}
+		}
+		a.post(w, ev)
+	}(item.path, output)
+}
+
+// loadPreview decodes the image at path for the comparison viewer.
+func loadPreview(path string) (comparedImage, error) {
+	img, width, height, err := controllers.Thumbnail(path, previewPixels)
+	if err != nil {
+		return comparedImage{}, err
+	}
+	preview := comparedImage{
+		img:    paint.NewImageOp(img),
+		size:   img.Bounds().Size(),
+		width:  width,
+		height: height,
+	}
+	if info, err := os.Stat(path); err == nil {
+		preview.bytes = info.Size()
+	}
+	return preview, nil
+}
+
+// compareLoadedEvent delivers the decoded images of the compared file.
+type compareLoadedEvent struct {
+	item   *FileItem
+	before comparedImage
+	after  *comparedImage
+	err    error
+}
+
+func (e compareLoadedEvent) apply(a *App) {
+	v := &a.compare
+	if v.item != e.item {
+		// Another file was opened since
+		return
+	}
+	v.loading = false
+	v.before, v.after, v.err = e.before, e.after, e.err
+	v.center = layout.FPt(v.before.size).Mul(0.5)
+}
+
+// handleCompareClicks handles the viewer's buttons and the selection of
+// files to compare.
+func (a *App) handleCompareClicks(gtx layout.Context, w *app.Window) {
+	for _, item := range a.fileItems {
+		if item.selectBtn.Clicked(gtx) {
+			a.openCompare(w, item)
+		}
+	}
+
+	v := &a.compare
+	if v.item == nil {
+		return
+	}
+	if v.closeBtn.Clicked(gtx) {
+		v.item = nil
+		v.before, v.after = comparedImage{}, nil
+		return
+	}
+	if i := slices.Index(a.fileItems, v.item); i >= 0 {
+		if v.prevBtn.Clicked(gtx) && i > 0 {
+			a.openCompare(w, a.fileItems[i-1])
+		}
+		if v.nextBtn.Clicked(gtx) && i < len(a.fileItems)-1 {
+			a.openCompare(w, a.fileItems[i+1])
+		}
+	}
+	if v.zoomInBtn.Clicked(gtx) {
+		v.zoomAt(zoomStep, f32.Point{})
+	}
+	if v.zoomOutBtn.Clicked(gtx) {
+		v.zoomAt(1/zoomStep, f32.Point{})
+	}
+	if v.fitBtn.Clicked(gtx) {
+		v.scale = 0
+		v.center = layout.FPt(v.before.size).Mul(0.5)
+	}
+	if v.actualBtn.Clicked(gtx) && v.before.size.X > 0 {
+		v.zoomAt(v.pixelRatio()/v.currentScale(), f32.Point{})
+	}
+}
+
+// pixelRatio returns how many file pixels one preview pixel covers, which
+// is above 1 only for images larger than previewPixels.
+func (v *compareView) pixelRatio() float32 {
+	if v.before.size.X == 0 {
+		return 1
+	}
+	return float32(v.before.width) / float32(v.before.size.X)
+}
+
+// currentScale returns the scale in effect, resolving fit.
+func (v *compareView) currentScale() float32 {
+	if v.scale == 0 {
+		return v.fit
+	}
+	return v.scale
+}
+
+// zoomAt multiplies the scale by factor, keeping the preview point at p,
+// relative to the pane centre, in place.
+func (v *compareView) zoomAt(factor float32, p f32.Point) {
+	old := v.currentScale()
+	if old <= 0 {
+		return
+	}
+	anchor := v.center.Add(p.Div(old))
+
+	// Large images zoom out to fit, small ones down to 100%
+	ratio := v.pixelRatio()
+	scale := min(max(old*factor, min(v.fit, ratio)), max(maxZoom*ratio, v.fit))
+	if scale <= v.fit && v.fit <= ratio {
+		v.scale = 0
+		v.center = layout.FPt(v.before.size).Mul(0.5)
+		return
+	}
+	v.scale = scale
+	v.center = anchor.Sub(p.Div(scale))
+	v.clampCenter()
+}
+
+// pan moves the images by delta screen pixels.
+func (v *compareView) pan(delta f32.Point) {
+	if v.scale == 0 {
+		return
+	}
+	v.center = v.center.Sub(delta.Div(v.scale))
+	v.clampCenter()
+}
+
+// clampCenter keeps the centre of the panes within the image.
+func (v *compareView) clampCenter() {
+	size := layout.FPt(v.before.size)
+	v.center.X = min(max(v.center.X, 0), size.X)
+	v.center.Y = min(max(v.center.Y, 0), size.Y)
+}
+
+// zoomText returns the magnification relative to the file, e.g. "125%".
+func (v *compareView) zoomText() string {
+	return fmt.Sprintf("%.0f%%", v.currentScale()/v.pixelRatio()*100)
+}
+
+// panes returns the rectangles the images are drawn in within size.
+func (v *compareView) panes(gtx layout.Context, size image.Point) []image.Rectangle {
+	if v.mode.Value == compareSplit {
+		return []image.Rectangle{{Max: size}}
+	}
+	gap := gtx.Dp(unit.Dp(8))
+	half := (size.X - gap) / 2
+	return []image.Rectangle{
+		image.Rect(0, 0, half, size.Y),
+		image.Rect(size.X-half, 0, size.X, size.Y),
+	}
+}
+
+// handleInput pans on drag, zooms on scroll and moves the split line when it
+// is dragged.
+func (v *compareView) handleInput(gtx layout.Context, size image.Point, panes []image.Rectangle) {
+	for {
+		ev, ok := gtx.Event(pointer.Filter{
+			Target:  v,
+			Kinds:   pointer.Press | pointer.Drag | pointer.Release | pointer.Cancel | pointer.Scroll,
+			ScrollY: pointer.ScrollRange{Min: math.MinInt32, Max: math.MaxInt32},
+		})
+		if !ok {
+			break
+		}
+		e, ok := ev.(pointer.Event)
+		if !ok {
+			continue
+		}
+
+		switch e.Kind {
+		case pointer.Press:
+			splitX := v.split * float32(size.X)
+			v.dragging = true
+			v.dragSplit = v.mode.Value == compareSplit && abs(e.Position.X-splitX) <= float32(gtx.Dp(unit.Dp(8)))
+			v.last = e.Position
+		case pointer.Drag:
+			if !v.dragging {
+				continue
+			}
+			if v.dragSplit {
+				v.split = min(max(e.Position.X/float32(size.X), 0), 1)
+			} else {
+				v.pan(e.Position.Sub(v.last))
+			}
+			v.last = e.Position
+		case pointer.Release, pointer.Cancel:
+			v.dragging = false
+		case pointer.Scroll:
+			factor := float32(zoomStep)
+			if e.Scroll.Y > 0 {
+				factor = 1 / zoomStep
+			}
+			pane := panes[0]
+			for _, r := range panes {
+				if e.Position.Round().In(r) {
+					pane = r
+				}
+			}
+			center := layout.FPt(pane.Min.Add(pane.Max)).Mul(0.5)
+			v.zoomAt(factor, e.Position.Sub(center))
+		}
+	}
+}
+
+func abs(x float32) float32 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// drawPreview draws the part of img within area, positioned for pane at the
+// shared scale and centre. The after image is stretched over the before
+// image when its size differs.
+func (v *compareView) drawPreview(gtx layout.Context, img comparedImage, pane, area image.Rectangle) {
+	defer clip.Rect(area).Push(gtx.Ops).Pop()
+
+	scale := v.currentScale()
+	origin := layout.FPt(pane.Min.Add(pane.Max)).Mul(0.5).Sub(v.center.Mul(scale))
+	sx := scale * float32(v.before.size.X) / float32(img.size.X)
+	sy := scale * float32(v.before.size.Y) / float32(img.size.Y)
+	defer op.Affine(f32.Affine2D{}.Scale(f32.Point{}, f32.Pt(sx, sy)).Offset(origin)).Push(gtx.Ops).Pop()
+	defer clip.Rect{Max: img.size}.Push(gtx.Ops).Pop()
+
+	// Show individual pixels once zoomed in
+	if sx >= 2 {
+		img.img.Filter = paint.FilterNearest
+	}
+	img.img.Add(gtx.Ops)
+	paint.PaintOp{}.Add(gtx.Ops)
+}
+
+// layoutPaneLabel draws text on a background at the top left of pane.
+func (a *App) layoutPaneLabel(gtx layout.Context, pane image.Rectangle, text string) {
+	defer op.Offset(pane.Min.Add(image.Pt(gtx.Dp(unit.Dp(6)), gtx.Dp(unit.Dp(6))))).Push(gtx.Ops).Pop()
+
+	gtx.Constraints.Min = image.Point{}
+	layout.Background{}.Layout(gtx,
+		func(gtx layout.Context) layout.Dimensions {
+			paint.FillShape(gtx.Ops, a.theme.Bg, clip.Rect{Max: gtx.Constraints.Min}.Op())
+			return layout.Dimensions{Size: gtx.Constraints.Min}
+		},
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.UniformInset(unit.Dp(4)).Layout(gtx, material.Caption(a.theme, text).Layout)
+		},
+	)
+}
+
+// layoutCompareCanvas draws the images and handles panning, zooming and the
+// split line.
+func (a *App) layoutCompareCanvas(gtx layout.Context) layout.Dimensions {
+	v := &a.compare
+	size := gtx.Constraints.Max
+
+	if v.loading || v.err != nil {
+		text := "Loading..."
+		if v.err != nil {
+			text = fmt.Sprintf("Error: %v", v.err)
+		}
+		return layout.Center.Layout(gtx, material.Body2(a.theme, text).Layout)
+	}
+
+	panes := v.panes(gtx, size)
+	pane, preview := layout.FPt(panes[0].Size()), layout.FPt(v.before.size)
+	v.fit = min(pane.X/preview.X, pane.Y/preview.Y)
+	v.handleInput(gtx, size, panes)
+
+	missing := "No WebP yet. Convert this file to compare it."
+	switch {
+	case v.mode.Value == compareSplit && v.after != nil:
+		// Both halves are positioned for the whole pane so they line up
+		splitX := int(v.split * float32(size.X))
+		left, right := panes[0], panes[0]
+		left.Max.X, right.Min.X = splitX, splitX
+		v.drawPreview(gtx, v.before, panes[0], left)
+		v.drawPreview(gtx, *v.after, panes[0], right)
+
+		line := gtx.Dp(unit.Dp(1))
+		paint.FillShape(gtx.Ops, splitLineColor, clip.Rect{Min: image.Pt(splitX-line, 0), Max: image.Pt(splitX+line, size.Y)}.Op())
+		a.layoutPaneLabel(gtx, panes[0], "Original")
+		a.layoutPaneLabel(gtx, right, "WebP")
+
+		// Widen the grab area of the line
+		grab := gtx.Dp(unit.Dp(8))
+		area := clip.Rect{Min: image.Pt(splitX-grab, 0), Max: image.Pt(splitX+grab, size.Y)}.Push(gtx.Ops)
+		pointer.CursorColResize.Add(gtx.Ops)
+		area.Pop()
+	case v.mode.Value == compareSplit:
+		v.drawPreview(gtx, v.before, panes[0], panes[0])
+		a.layoutPaneLabel(gtx, panes[0], "Original. "+missing)
+	default:
+		v.drawPreview(gtx, v.before, panes[0], panes[0])
+		a.layoutPaneLabel(gtx, panes[0], "Original")
+		if v.after != nil {
+			v.drawPreview(gtx, *v.after, panes[1], panes[1])
+			a.layoutPaneLabel(gtx, panes[1], "WebP")
+		} else {
+			a.layoutPaneLabel(gtx, panes[1], missing)
+		}
+	}
+
+	// Receive pointer input over the whole canvas
+	area := clip.Rect{Max: size}.Push(gtx.Ops)
+	if v.scale > 0 {
+		pointer.CursorGrab.Add(gtx.Ops)
+	}
+	event.Op(gtx.Ops, v)
+	area.Pop()
+
+	return layout.Dimensions{Size: size}
+}
+
+// compareSizes describes both files, e.g.
+// "Original: 2.1 MB, 4032×3024   WebP: 300.0 KB, 4032×3024 (2.1 MB -> 300.0 KB, -86%)".
+func (v *compareView) compareSizes() string {
+	if v.loading || v.err != nil {
+		return ""
+	}
+	line := fmt.Sprintf("Original: %s, %d×%d", controllers.FormatSize(v.before.bytes), v.before.width, v.before.height)
+	if v.after != nil {
+		line += fmt.Sprintf("   WebP: %s, %d×%d (%s)", controllers.FormatSize(v.after.bytes), v.after.width, v.after.height, sizeChange(v.before.bytes, v.after.bytes))
+	}
+	return line
+}
+
+// layoutCompare draws the comparison viewer of the selected file.
+func (a *App) layoutCompare(gtx layout.Context) layout.Dimensions {
+	v := &a.compare
+	button := func(clickable *widget.Clickable, text string, enabled bool) layout.FlexChild {
+		return layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Right: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				if !enabled {
+					gtx = gtx.Disabled()
+				}
+				btn := material.Button(a.theme, clickable, text)
+				btn.CornerRadius = unit.Dp(4)
+				return btn.Layout(gtx)
+			})
+		})
+	}
+	i := slices.Index(a.fileItems, v.item)
+	ready := !v.loading && v.err == nil
+
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		// File name and navigation
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{
+					Axis:      layout.Horizontal,
+					Alignment: layout.Middle,
+				}.Layout(gtx,
+					button(&v.closeBtn, "Close", true),
+					button(&v.prevBtn, "◀", i > 0),
+					button(&v.nextBtn, "▶", i >= 0 && i < len(a.fileItems)-1),
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						label := material.Body1(a.theme, filepath.Base(v.item.path))
+						label.MaxLines = 1
+						return label.Layout(gtx)
+					}),
+				)
+			})
+		}),
+
+		// View mode and zoom
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{
+					Axis:      layout.Horizontal,
+					Alignment: layout.Middle,
+				}.Layout(gtx,
+					layout.Rigid(material.RadioButton(a.theme, &v.mode, compareSideBySide, "Side by side").Layout),
+					layout.Rigid(material.RadioButton(a.theme, &v.mode, compareSplit, "Split").Layout),
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						return layout.Dimensions{Size: gtx.Constraints.Min}
+					}),
+					button(&v.zoomOutBtn, "−", ready),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Right: unit.Dp(8)}.Layout(gtx, material.Body2(a.theme, v.zoomText()).Layout)
+					}),
+					button(&v.zoomInBtn, "+", ready),
+					button(&v.fitBtn, "Fit", ready),
+					button(&v.actualBtn, "100%", ready),
+				)
+			})
+		}),
+
+		// Images
+		layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(10)}.Layout(gtx, a.layoutCompareCanvas)
+		}),
+
+		// File sizes
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(10)}.Layout(gtx, material.Body2(a.theme, v.compareSizes()).Layout)
+		}),
+
+		// Quality, with the size this file would have
+		layout.Rigid(a.layoutQuality),
+	)
+}
@@ -1,6 +1,7 @@
 package components
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -15,7 +16,6 @@ import (
 	"gioui.org/widget"
 	"gioui.org/widget/material"
 	"github.com/Sakaino2/image-compressor/controllers"
-	"github.com/chai2010/webp"
 )
 
 type App struct {
@@ -200,7 +200,7 @@ func (a *App) convertImage() {
 	}
 
 	// Parse quality
-	var quality float32 = 80
+	opts := controllers.DefaultOptions()
 	if qualityStr != "" {
 		var q int
 		_, err := fmt.Sscanf(qualityStr, "%d", &q)
@@ -209,7 +209,7 @@ func (a *App) convertImage() {
 			a.window.Invalidate()
 			return
 		}
-		quality = float32(q)
+		opts.Quality = float32(q)
 	}
 
 	// Generate output path if not provided
@@ -221,39 +221,13 @@ func (a *App) convertImage() {
 	a.statusText = "Converting..."
 	a.window.Invalidate()
 
-	// Open input file
-	file, err := os.Open(inputPath)
+	report, err := controllers.NewConverter().ConvertFile(context.Background(), inputPath, "", outputPath, opts)
 	if err != nil {
-		a.statusText = fmt.Sprintf("Error opening file: %v", err)
+		a.statusText = fmt.Sprintf("Error: %v", err)
 		a.window.Invalidate()
 		return
 	}
-	defer file.Close()
 
-	img, err := controllers.DecodeImage(file, inputPath)
-	if err != nil {
-		a.statusText = fmt.Sprintf("Error decoding image: %v", err)
-		a.window.Invalidate()
-		return
-	}
-
-	// Create output file
-	outFile, err := os.Create(outputPath)
-	if err != nil {
-		a.statusText = fmt.Sprintf("Error creating output file: %v", err)
-		a.window.Invalidate()
-		return
-	}
-	defer outFile.Close()
-
-	// Encode as WebP
-	err = webp.Encode(outFile, *img, &webp.Options{Quality: quality})
-	if err != nil {
-		a.statusText = fmt.Sprintf("Error encoding WebP: %v", err)
-		a.window.Invalidate()
-		return
-	}
-
-	a.statusText = fmt.Sprintf("Success! Saved to: %s", outputPath)
+	a.statusText = fmt.Sprintf("Success! Saved to: %s", report.OutputPath)
 	a.window.Invalidate()
 }
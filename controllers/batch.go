@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// Job is a single file conversion within a batch.
+type Job struct {
+	// Index is the position of the job in the batch, so callers can match
+	// results that arrive out of order.
+	Index     int
+	InputPath string
+
+	// OutputDir and OutputPath are passed to ConvertFile: only
+	// OutputPath is expanded as a template.
+	OutputDir  string
+	OutputPath string
+}
+
+// Result reports the outcome of a Job. Err is nil on success.
+type Result struct {
+	Job
+	Report Report
+	Err    error
+}
+
+// DefaultConcurrency returns the number of workers used when none is set.
+func DefaultConcurrency() int {
+	return runtime.NumCPU()
+}
+
+// BatchOptions controls how ConvertBatch runs, as opposed to how each file is
+// converted.
+type BatchOptions struct {
+	// Concurrency is the number of files in flight at once. Below 1,
+	// DefaultConcurrency is used.
+	Concurrency int
+
+	// OnStart, when set, is called as a worker picks up each job, so
+	// callers can show what is being encoded. It runs on the worker
+	// goroutine and must not block.
+	OnStart func(Job)
+}
+
+// ConvertBatch converts jobs with at most batch.Concurrency files in flight
+// at once and streams each Result on the returned channel as soon as it
+// finishes. The channel is closed after every job has been reported.
+//
+// Cancelling ctx stops the batch: jobs that have not started are reported
+// with ctx.Err() and jobs in flight are abandoned without leaving output
+// behind. The caller must drain the channel either way.
+//
+// Before starting, stale temp files left in the output directories by an
+// earlier run that was killed mid-conversion are removed. Directories named
+// by template tokens are found through the temp journal.
+func (c *Converter) ConvertBatch(ctx context.Context, jobs []Job, opts Options, batch BatchOptions) <-chan Result {
+	dirs, templated := outputDirs(jobs)
+	for _, dir := range dirs {
+		CleanupTempFiles(dir, false)
+	}
+	if templated {
+		// Rather than walking whole trees the template might fill, clean
+		// the directories earlier runs are known to have written to
+		CleanupRecordedTempFiles()
+	}
+
+	concurrency := batch.Concurrency
+	if concurrency < 1 {
+		concurrency = DefaultConcurrency()
+	}
+	if concurrency > len(jobs) {
+		concurrency = len(jobs)
+	}
+
+	pending := make(chan Job)
+	results := make(chan Result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range pending {
+				if batch.OnStart != nil {
+					batch.OnStart(job)
+				}
+				report, err := c.ConvertFile(ctx, job.InputPath, job.OutputDir, job.OutputPath, opts)
+				results <- Result{Job: job, Report: report, Err: err}
+			}
+		}()
+	}
+
+	go func() {
+		dispatched := 0
+	dispatch:
+		for _, job := range jobs {
+			select {
+			case pending <- job:
+				dispatched++
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+		close(pending)
+
+		// Report the jobs that were never started
+		for _, job := range jobs[dispatched:] {
+			results <- Result{Job: job, Err: ctx.Err()}
+		}
+
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
@@ -0,0 +1,560 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chai2010/webp"
+)
+
+// Options controls how an image is encoded to WebP.
+type Options struct {
+	// Quality is the lossy encoding quality, from 1 to 100.
+	Quality float32
+
+	// Lossless selects lossless encoding, in which case Quality is ignored.
+	Lossless bool
+
+	// Exact keeps the RGB values under fully transparent pixels instead of
+	// letting the encoder discard them. It only applies to lossless encoding.
+	Exact bool
+
+	// Resize is applied between decoding and encoding.
+	Resize ResizeOptions
+
+	// Metadata selects which source metadata is kept. It defaults to
+	// MetadataStrip.
+	Metadata MetadataPolicy
+
+	// Collision decides what ConvertFile does when the output file already
+	// exists. It defaults to CollisionOverwrite.
+	Collision CollisionPolicy
+
+	// TargetSize, when positive, replaces Quality with the highest quality
+	// whose output, metadata included, is at most this many bytes. It only
+	// applies to lossy encoding.
+	TargetSize int64
+
+	// MinSSIM, when positive, replaces Quality with the lowest quality whose
+	// decoded output keeps at least this SSIM against the source, from 0 to
+	// 1. It only applies to lossy encoding and excludes TargetSize.
+	MinSSIM float64
+
+	// Larger decides what happens when the WebP is bigger than the source.
+	// It defaults to LargerWrite.
+	Larger LargerPolicy
+
+	// Incremental makes ConvertFile skip sources whose output is up to
+	// date. IncrementalStrict needs a Manifest, which records the settings
+	// of every conversion.
+	Incremental IncrementalMode
+	Manifest    *Manifest
+}
+
+// DefaultOptions returns the settings used when the user changes nothing.
+func DefaultOptions() Options {
+	return Options{Quality: 80}
+}
+
+// Validate reports whether the options can be used for encoding.
+func (o Options) Validate() error {
+	if !o.Lossless && (o.Quality < 1 || o.Quality > 100) {
+		return fmt.Errorf("quality must be between 1 and 100")
+	}
+
+	if o.TargetSize < 0 {
+		return fmt.Errorf("target size must not be negative")
+	}
+	if o.TargetSize > 0 && o.Lossless {
+		return fmt.Errorf("target size needs lossy encoding")
+	}
+	if o.MinSSIM < 0 || o.MinSSIM >= 1 {
+		return fmt.Errorf("minimum SSIM must be between 0 and 1")
+	}
+	if o.MinSSIM > 0 && o.Lossless {
+		return fmt.Errorf("minimum SSIM needs lossy encoding")
+	}
+	if o.MinSSIM > 0 && o.TargetSize > 0 {
+		return fmt.Errorf("choose either a target size or a minimum SSIM")
+	}
+
+	switch o.Metadata {
+	case "", MetadataStrip, MetadataKeep, MetadataCopyright:
+	default:
+		return fmt.Errorf("unknown metadata policy %q", o.Metadata)
+	}
+
+	switch o.Collision {
+	case "", CollisionOverwrite, CollisionSkip, CollisionSuffix, CollisionError:
+	default:
+		return fmt.Errorf("unknown collision policy %q", o.Collision)
+	}
+
+	switch o.Larger {
+	case "", LargerWrite, LargerSkip, LargerCopy:
+	default:
+		return fmt.Errorf("unknown larger-output policy %q", o.Larger)
+	}
+
+	switch o.Incremental {
+	case "", IncrementalOff, IncrementalNewer:
+	case IncrementalStrict:
+		if o.Manifest == nil {
+			return fmt.Errorf("strict incremental mode needs a manifest")
+		}
+	default:
+		return fmt.Errorf("unknown incremental mode %q", o.Incremental)
+	}
+
+	return o.Resize.Validate()
+}
+
+// searchesQuality reports whether the quality is picked per image instead of
+// taken from Quality.
+func (o Options) searchesQuality() bool {
+	return !o.Lossless && (o.TargetSize > 0 || o.MinSSIM > 0)
+}
+
+// Converter decodes images and re-encodes them as WebP. It has no GUI
+// dependencies, so the desktop app, the CLI and other programs share it.
+// The zero value is ready to use and a Converter is safe for concurrent use.
+type Converter struct{}
+
+// NewConverter returns a Converter.
+func NewConverter() *Converter {
+	return &Converter{}
+}
+
+// Convert decodes an image from src, detecting its format from the content,
+// and writes it to dst as WebP.
+func (c *Converter) Convert(ctx context.Context, src io.Reader, dst io.Writer, opts Options) error {
+	source, err := c.load(ctx, src, "", opts)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.encodeBytes(ctx, source, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := dst.Write(result.data); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	return nil
+}
+
+// Report describes the output of a ConvertFile call.
+type Report struct {
+	// OutputPath is the file actually written, after template tokens are
+	// filled in and any collision suffix is added.
+	OutputPath string
+
+	// Skipped is set when nothing was written, either because the output
+	// already existed and opts.Collision is CollisionSkip or because it is
+	// up to date.
+	Skipped bool
+
+	// Quality is the lossy quality used, which opts.TargetSize or
+	// opts.MinSSIM pick per image. It does not apply when Lossless is set.
+	Quality  float32
+	Lossless bool
+
+	// InputFormat is the format of the source, as named by the Format
+	// constants.
+	InputFormat string
+
+	// Width and Height are the dimensions of the output image.
+	Width  int
+	Height int
+
+	// Duration is the time ConvertFile took.
+	Duration time.Duration
+
+	// InputBytes and OutputBytes are the sizes of the source and of the
+	// file written. OutputBytes is 0 when nothing was written, except for
+	// up-to-date outputs, whose current size is reported.
+	InputBytes  int64
+	OutputBytes int64
+
+	// Larger is set when the WebP came out bigger than the source, in which
+	// case opts.Larger decides what was written. Copied is set when the
+	// original was copied instead.
+	Larger bool
+	Copied bool
+
+	// SSIM is the measured similarity of the output to the source when
+	// opts.MinSSIM is set, and 0 otherwise.
+	SSIM float64
+
+	// UpToDate is set along with Skipped when opts.Incremental found the
+	// output of an earlier run still current.
+	UpToDate bool
+}
+
+// Saved returns how many bytes the conversion saved compared to the source.
+// It is negative when a larger WebP was written, and 0 when nothing new was
+// written.
+func (r Report) Saved() int64 {
+	if r.Skipped || r.Copied {
+		return 0
+	}
+	return r.InputBytes - r.OutputBytes
+}
+
+// ConvertFile converts the image at inputPath and writes the WebP result to
+// outputPath within outputDir. outputPath may contain the tokens listed in
+// TemplateTokens; outputDir is taken literally, so folders whose names look
+// like tokens are left alone. An empty outputDir makes outputPath the whole
+// path. An existing file is handled according to opts.Collision, and a WebP larger
+// than the source according to opts.Larger. An outputPath that names the
+// source itself fails with ErrOutputIsSource whatever the collision policy.
+// The output is written to a temp file in the same directory and renamed
+// into place only once it is complete and synced, so a failed, cancelled or
+// killed conversion never leaves a partial file at outputPath.
+func (c *Converter) ConvertFile(ctx context.Context, inputPath, outputDir, outputPath string, opts Options) (Report, error) {
+	start := time.Now()
+	report, err := c.convertFile(ctx, inputPath, outputDir, outputPath, opts)
+	report.Duration = time.Since(start)
+	return report, err
+}
+
+func (c *Converter) convertFile(ctx context.Context, inputPath, outputDir, outputPath string, opts Options) (Report, error) {
+	// Open input file
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return Report{}, fmt.Errorf("opening file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return Report{}, fmt.Errorf("opening file: %w", err)
+	}
+
+	data, err := c.read(ctx, file, opts)
+	if err != nil {
+		return Report{}, err
+	}
+
+	// Name the output before decoding, so that up-to-date files are skipped
+	// cheaply. Formats DecodeConfig can't size are decoded first.
+	var source *source
+	width, height, ok := probeSize(data, opts.Resize)
+	if !ok {
+		if source, err = c.decode(ctx, data, inputPath, opts); err != nil {
+			return Report{}, err
+		}
+		bounds := source.img.Bounds()
+		width, height = bounds.Dx(), bounds.Dy()
+	}
+	name := expandTemplate(outputPath, templateVars{
+		inputPath: inputPath,
+		width:     width,
+		height:    height,
+		opts:      opts,
+		data:      data,
+		modTime:   info.ModTime(),
+	})
+	outputPath = filepath.Join(outputDir, name)
+
+	format := DetectFormat(data)
+	if format == "" {
+		format = FormatForExtension(inputPath)
+	}
+	report := Report{OutputPath: outputPath, InputFormat: format, Width: width, Height: height}
+
+	// Don't spend an encode on a file that would be thrown away. A searched
+	// {quality} leaves the path unfinished until after encoding, when the
+	// checks run instead; only the manifest can vouch for such an output
+	// beforehand.
+	target := outputPath
+	deferred := strings.Contains(name, "{")
+	if deferred && opts.Incremental == IncrementalStrict {
+		if done := checkUpToDate(&report, inputPath, outputPath, info, data, opts); done {
+			return report, nil
+		}
+	}
+	if !deferred {
+		if done, err := checkOutput(&report, inputPath, outputPath, info, data, opts); done {
+			return report, err
+		}
+	}
+
+	if source == nil {
+		if source, err = c.decode(ctx, data, inputPath, opts); err != nil {
+			return report, err
+		}
+	}
+
+	result, err := c.encodeBytes(ctx, source, opts)
+	if err == nil {
+		// A conversion cancelled mid-encode does not count as finished
+		err = ctx.Err()
+	}
+	if err != nil {
+		return report, err
+	}
+	report.Quality = result.quality
+	report.Lossless = opts.Lossless
+	report.SSIM = result.ssim
+
+	// A searched quality is only known now
+	if deferred {
+		outputPath = filepath.Join(outputDir, strings.ReplaceAll(name, "{quality}", formatQuality(result.quality, opts)))
+		report.OutputPath = outputPath
+		if done, err := checkOutput(&report, inputPath, outputPath, info, data, opts); done {
+			return report, err
+		}
+	}
+
+	// Keep the original when the WebP doesn't pay off
+	payload := result.data
+	report.InputBytes = int64(len(data))
+	if len(result.data) > len(data) {
+		report.Larger = true
+		switch opts.Larger {
+		case LargerSkip:
+			report.Skipped = true
+			opts.recordOutcome(inputPath, target, "", data)
+			return report, nil
+		case LargerCopy:
+			payload = data
+			report.Copied = true
+			outputPath = originalPath(outputPath, inputPath)
+			report.OutputPath = outputPath
+			if sameFile(outputPath, inputPath) {
+				// The original already is where the copy would go
+				report.OutputBytes = report.InputBytes
+				opts.recordOutcome(inputPath, target, outputPath, data)
+				return report, nil
+			}
+		}
+	}
+	report.OutputBytes = int64(len(payload))
+
+	report.OutputPath, report.Skipped, err = writeOutput(outputPath, payload, opts.Collision)
+	if err != nil {
+		return report, err
+	}
+
+	if !report.Skipped {
+		opts.recordOutcome(inputPath, target, report.OutputPath, data)
+	}
+
+	return report, nil
+}
+
+// Estimate encodes the image at inputPath in memory, exactly as ConvertFile
+// would, and reports the result without writing anything. The report has no
+// OutputPath and ignores opts.Larger.
+func (c *Converter) Estimate(ctx context.Context, inputPath string, opts Options) (Report, error) {
+	start := time.Now()
+
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return Report{}, fmt.Errorf("opening file: %w", err)
+	}
+	defer file.Close()
+
+	source, err := c.load(ctx, file, inputPath, opts)
+	if err != nil {
+		return Report{}, err
+	}
+
+	result, err := c.encodeBytes(ctx, source, opts)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return Report{}, err
+	}
+
+	format := DetectFormat(source.data)
+	if format == "" {
+		format = FormatForExtension(inputPath)
+	}
+	bounds := source.img.Bounds()
+	return Report{
+		Quality:     result.quality,
+		Lossless:    opts.Lossless,
+		InputFormat: format,
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		Duration:    time.Since(start),
+		InputBytes:  int64(len(source.data)),
+		OutputBytes: int64(len(result.data)),
+		Larger:      len(result.data) > len(source.data),
+		SSIM:        result.ssim,
+	}, nil
+}
+
+// checkOutput runs the checks that only need the output path: it refuses to
+// overwrite the source, keeps an up-to-date output and applies opts.Collision
+// to an existing file. done is set when the conversion ends there, with
+// report filled in and err as its outcome.
+func checkOutput(report *Report, inputPath, outputPath string, info os.FileInfo, data []byte, opts Options) (done bool, err error) {
+	if sameFile(outputPath, inputPath) {
+		return true, sourceOutputError()
+	}
+	if checkUpToDate(report, inputPath, outputPath, info, data, opts) {
+		return true, nil
+	}
+	if outputExists(outputPath) {
+		switch opts.Collision {
+		case CollisionSkip:
+			report.Skipped = true
+			return true, nil
+		case CollisionError:
+			return true, fmt.Errorf("creating output: %w", ErrOutputExists)
+		}
+	}
+	return false, nil
+}
+
+// checkUpToDate reports whether an earlier output for inputPath can be kept,
+// in which case report describes it.
+func checkUpToDate(report *Report, inputPath, outputPath string, info os.FileInfo, data []byte, opts Options) bool {
+	previous, ok := opts.upToDate(inputPath, outputPath, info, data)
+	if !ok {
+		return false
+	}
+	report.OutputPath = previous
+	report.Skipped = true
+	report.UpToDate = true
+	// Nothing was written last time because the WebP came out larger
+	report.Larger = previous == ""
+	report.InputBytes = int64(len(data))
+	if out, err := os.Stat(previous); err == nil {
+		report.OutputBytes = out.Size()
+	}
+	return true
+}
+
+// sourceOutputError explains how to avoid ErrOutputIsSource.
+func sourceOutputError() error {
+	return fmt.Errorf("creating output: %w; choose an output directory or another name template", ErrOutputIsSource)
+}
+
+// source is a decoded image ready for encoding.
+type source struct {
+	img  image.Image
+	meta Metadata
+
+	// data is the undecoded source file.
+	data []byte
+}
+
+// load reads and decodes an image and prepares it for encoding.
+func (c *Converter) load(ctx context.Context, src io.Reader, inputPath string, opts Options) (*source, error) {
+	data, err := c.read(ctx, src, opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.decode(ctx, data, inputPath, opts)
+}
+
+// read validates opts and reads the undecoded image.
+func (c *Converter) read(ctx context.Context, src io.Reader, opts Options) ([]byte, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("reading image: %w", err)
+	}
+	return data, nil
+}
+
+// decode decodes data and prepares it for encoding.
+func (c *Converter) decode(ctx context.Context, data []byte, inputPath string, opts Options) (*source, error) {
+	// Decode image
+	img, err := DecodeImage(bytes.NewReader(data), inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return &source{
+		img:  Resize(*img, opts.Resize),
+		meta: ExtractMetadata(data).Filter(opts.Metadata),
+		data: data,
+	}, nil
+}
+
+// probeSize returns the size the output of data will have, reading only the
+// image header. ok is false when the header can't be read.
+func probeSize(data []byte, resize ResizeOptions) (width, height int, ok bool) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, false
+	}
+
+	width, height = config.Width, config.Height
+	if DetectFormat(data) == FormatJPEG && JPEGOrientation(data) >= OrientationTranspose {
+		// The orientations from Transpose on swap width and height
+		width, height = height, width
+	}
+	width, height = resize.Size(width, height)
+	return width, height, true
+}
+
+// encoded is a finished WebP encoding.
+type encoded struct {
+	data    []byte
+	quality float32
+
+	// ssim is the similarity to the source, measured only for MinSSIM.
+	ssim float64
+}
+
+// encodeBytes encodes source as WebP in memory. With opts.TargetSize or
+// opts.MinSSIM set, the quality is searched for.
+func (c *Converter) encodeBytes(ctx context.Context, source *source, opts Options) (*encoded, error) {
+	switch {
+	case opts.TargetSize > 0 && !opts.Lossless:
+		return c.encodeTarget(ctx, source, opts)
+	case opts.MinSSIM > 0 && !opts.Lossless:
+		return c.encodePerceptual(ctx, source, opts)
+	}
+	data, err := c.encodeQuality(source, opts, opts.Quality)
+	if err != nil {
+		return nil, err
+	}
+	return &encoded{data: data, quality: opts.Quality}, nil
+}
+
+// encodeQuality encodes source at quality, embedding its metadata.
+func (c *Converter) encodeQuality(source *source, opts Options, quality float32) ([]byte, error) {
+	// Encode as WebP
+	var buf bytes.Buffer
+	err := webp.Encode(&buf, source.img, &webp.Options{
+		Lossless: opts.Lossless,
+		Quality:  quality,
+		Exact:    opts.Exact,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encoding webp: %w", err)
+	}
+
+	data := buf.Bytes()
+	if !source.meta.IsEmpty() {
+		if data, err = source.meta.Embed(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
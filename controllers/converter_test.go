@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestImage writes a w×h PNG of smooth gradients with some noise to
+// path, so that WebP size and fidelity both vary with quality.
+func writeTestImage(t *testing.T, path string, w, h int) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	seed := uint32(1)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			seed = seed*1664525 + 1013904223
+			noise := uint8(seed >> 28)
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x*4) + noise, G: uint8(y*4) + noise, B: uint8(x*y) + noise, A: 0xFF})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// backdate makes path look older than anything written by the test.
+func backdate(t *testing.T, path string) {
+	t.Helper()
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// searchedQualityOptions are settings that pick the quality per image, which
+// leaves {quality} unknown until the image is encoded.
+var searchedQualityOptions = []struct {
+	name string
+	opts Options
+}{
+	{"target size", Options{Quality: 80, TargetSize: 3000}},
+	{"min SSIM", Options{Quality: 80, MinSSIM: 0.9}},
+}
+
+// TestSearchedQualityOutputChecks checks that an output named after a
+// searched quality is held to the same up-to-date, source and collision
+// checks as any other.
+func TestSearchedQualityOutputChecks(t *testing.T) {
+	ctx := context.Background()
+	c := NewConverter()
+
+	for _, tt := range searchedQualityOptions {
+		t.Run(tt.name+"/newer", func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "photo.png")
+			writeTestImage(t, input, 64, 64)
+			backdate(t, input)
+			pattern := "{name}_q{quality}.webp"
+
+			opts := tt.opts
+			opts.Incremental = IncrementalNewer
+			first, err := c.ConvertFile(ctx, input, dir, pattern, opts)
+			if err != nil || first.Skipped {
+				t.Fatalf("first run: skipped = %v, err = %v", first.Skipped, err)
+			}
+			want := filepath.Join(dir, fmt.Sprintf("photo_q%d.webp", int(first.Quality)))
+			if first.OutputPath != want {
+				t.Fatalf("first run wrote %s, want %s", first.OutputPath, want)
+			}
+
+			second, err := c.ConvertFile(ctx, input, dir, pattern, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !second.UpToDate || second.OutputPath != want {
+				t.Errorf("second run: up to date = %v, output %s; want up to date at %s", second.UpToDate, second.OutputPath, want)
+			}
+		})
+
+		t.Run(tt.name+"/collision", func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "photo.png")
+			writeTestImage(t, input, 64, 64)
+			pattern := "{name}_q{quality}.webp"
+
+			first, err := c.ConvertFile(ctx, input, dir, pattern, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(first.OutputPath, []byte("keep"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := tt.opts
+			opts.Collision = CollisionSkip
+			report, err := c.ConvertFile(ctx, input, dir, pattern, opts)
+			if err != nil || !report.Skipped {
+				t.Errorf("skip: skipped = %v, err = %v", report.Skipped, err)
+			}
+			if data, _ := os.ReadFile(first.OutputPath); string(data) != "keep" {
+				t.Errorf("skip: existing output was replaced")
+			}
+
+			opts.Collision = CollisionError
+			if _, err := c.ConvertFile(ctx, input, dir, pattern, opts); !errors.Is(err, ErrOutputExists) {
+				t.Errorf("error: err = %v, want ErrOutputExists", err)
+			}
+		})
+
+		t.Run(tt.name+"/source", func(t *testing.T) {
+			dir := t.TempDir()
+			png := filepath.Join(dir, "photo.png")
+			writeTestImage(t, png, 64, 64)
+			converted, err := c.ConvertFile(ctx, png, dir, "photo.webp", Options{Quality: 95})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Name the WebP source exactly as its own output will be
+			estimate, err := c.Estimate(ctx, converted.OutputPath, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			input := filepath.Join(dir, fmt.Sprintf("photo_q%d.webp", int(estimate.Quality)))
+			if err := os.Rename(converted.OutputPath, input); err != nil {
+				t.Fatal(err)
+			}
+			before, _ := os.ReadFile(input)
+
+			_, err = c.ConvertFile(ctx, input, dir, "photo_q{quality}.webp", tt.opts)
+			if !errors.Is(err, ErrOutputIsSource) {
+				t.Errorf("err = %v, want ErrOutputIsSource", err)
+			}
+			if after, _ := os.ReadFile(input); !bytes.Equal(before, after) {
+				t.Errorf("source was overwritten")
+			}
+		})
+	}
+}
+
+// TestTokenNamedFolders checks that only the template is expanded, not the
+// folders it is written into.
+func TestTokenNamedFolders(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "{date}", "{name}")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "photo.png")
+	writeTestImage(t, input, 16, 16)
+
+	report, err := NewConverter().ConvertFile(context.Background(), input, dir, "{name}-{width}.webp", Options{Quality: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "photo-16.webp"); report.OutputPath != want {
+		t.Errorf("output = %s, want %s", report.OutputPath, want)
+	}
+}
@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+// EXIF orientation values, as defined by the TIFF/EXIF specification.
+const (
+	OrientationNormal     = 1
+	OrientationFlipH      = 2
+	OrientationRotate180  = 3
+	OrientationFlipV      = 4
+	OrientationTranspose  = 5
+	OrientationRotate90   = 6
+	OrientationTransverse = 7
+	OrientationRotate270  = 8
+)
+
+const (
+	jpegMarkerSOI  = 0xD8
+	jpegMarkerEOI  = 0xD9
+	jpegMarkerSOS  = 0xDA
+	jpegMarkerAPP1 = 0xE1
+
+	exifTagOrientation = 0x0112
+	exifTypeShort      = 3
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// jpegSegments calls fn with the marker and payload of every JPEG segment
+// before the image data, stopping early when fn returns false.
+func jpegSegments(data []byte, fn func(marker byte, payload []byte) bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != jpegMarkerSOI {
+		return
+	}
+
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// Fill byte before the real marker
+			i++
+			continue
+		}
+		if marker == jpegMarkerSOS || marker == jpegMarkerEOI {
+			return
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			// Standalone markers have no length
+			i += 2
+			continue
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			return
+		}
+		if !fn(marker, data[i+4:i+2+length]) {
+			return
+		}
+		i += 2 + length
+	}
+}
+
+// jpegEXIF returns the TIFF-structured EXIF payload of a JPEG, or nil.
+func jpegEXIF(data []byte) []byte {
+	var exif []byte
+	jpegSegments(data, func(marker byte, payload []byte) bool {
+		if marker == jpegMarkerAPP1 && bytes.HasPrefix(payload, exifHeader) {
+			exif = payload[len(exifHeader):]
+			return false
+		}
+		return true
+	})
+	return exif
+}
+
+// exifByteOrder returns the byte order declared by a TIFF header.
+func exifByteOrder(tiff []byte) (binary.ByteOrder, bool) {
+	if len(tiff) < 8 {
+		return nil, false
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, false
+	}
+
+	if order.Uint16(tiff[2:4]) != 42 {
+		return nil, false
+	}
+	return order, true
+}
+
+// exifFindTag returns the byte order of a TIFF-structured EXIF payload and
+// the offset of the first-IFD entry for tag, or -1 when it is absent.
+func exifFindTag(tiff []byte, tag uint16) (binary.ByteOrder, int) {
+	order, ok := exifByteOrder(tiff)
+	if !ok {
+		return nil, -1
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return nil, -1
+	}
+
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:entry+2]) == tag {
+			return order, entry
+		}
+	}
+	return nil, -1
+}
+
+// exifOrientationOffset returns the offset of the orientation value in a
+// TIFF-structured EXIF payload, or -1 when it is absent.
+func exifOrientationOffset(tiff []byte) (binary.ByteOrder, int) {
+	order, entry := exifFindTag(tiff, exifTagOrientation)
+	if entry < 0 || order.Uint16(tiff[entry+2:entry+4]) != exifTypeShort {
+		return nil, -1
+	}
+	return order, entry + 8
+}
+
+// JPEGOrientation returns the EXIF orientation stored in JPEG data, or
+// OrientationNormal when there is none.
+func JPEGOrientation(data []byte) int {
+	tiff := jpegEXIF(data)
+	order, offset := exifOrientationOffset(tiff)
+	if offset < 0 {
+		return OrientationNormal
+	}
+
+	orientation := int(order.Uint16(tiff[offset : offset+2]))
+	if orientation < OrientationNormal || orientation > OrientationRotate270 {
+		return OrientationNormal
+	}
+	return orientation
+}
+
+// ApplyOrientation returns img transformed so that it displays upright for
+// the given EXIF orientation.
+func ApplyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= OrientationNormal || orientation > OrientationRotate270 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	dstW, dstH := w, h
+	if orientation >= OrientationTranspose {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case OrientationFlipH:
+				dx, dy = w-1-x, y
+			case OrientationRotate180:
+				dx, dy = w-1-x, h-1-y
+			case OrientationFlipV:
+				dx, dy = x, h-1-y
+			case OrientationTranspose:
+				dx, dy = y, x
+			case OrientationRotate90:
+				dx, dy = h-1-y, x
+			case OrientationTransverse:
+				dx, dy = h-1-y, w-1-x
+			case OrientationRotate270:
+				dx, dy = y, w-1-x
+			}
+			si := src.PixOffset(x, y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestApplyOrientation(t *testing.T) {
+	// Every pixel of the 2×3 source is distinct: R and G hold its position
+	src := image.NewRGBA(image.Rect(0, 0, 2, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 2; x++ {
+			src.SetRGBA(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xFF})
+		}
+	}
+	at := func(x, y int) color.RGBA { return src.RGBAAt(x, y) }
+	tl, tr, bl, br := at(0, 0), at(1, 0), at(0, 2), at(1, 2)
+
+	tests := []struct {
+		orientation int
+		w, h        int
+		// corners of the result: top-left, top-right, bottom-left,
+		// bottom-right
+		corners [4]color.RGBA
+	}{
+		{OrientationNormal, 2, 3, [4]color.RGBA{tl, tr, bl, br}},
+		{OrientationFlipH, 2, 3, [4]color.RGBA{tr, tl, br, bl}},
+		{OrientationRotate180, 2, 3, [4]color.RGBA{br, bl, tr, tl}},
+		{OrientationFlipV, 2, 3, [4]color.RGBA{bl, br, tl, tr}},
+		{OrientationTranspose, 3, 2, [4]color.RGBA{tl, bl, tr, br}},
+		{OrientationRotate90, 3, 2, [4]color.RGBA{bl, tl, br, tr}},
+		{OrientationTransverse, 3, 2, [4]color.RGBA{br, tr, bl, tl}},
+		{OrientationRotate270, 3, 2, [4]color.RGBA{tr, br, tl, bl}},
+	}
+
+	for _, tt := range tests {
+		img := ApplyOrientation(src, tt.orientation)
+		bounds := img.Bounds()
+		if bounds.Dx() != tt.w || bounds.Dy() != tt.h {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", tt.orientation, bounds.Dx(), bounds.Dy(), tt.w, tt.h)
+			continue
+		}
+		points := [4]image.Point{
+			{bounds.Min.X, bounds.Min.Y},
+			{bounds.Max.X - 1, bounds.Min.Y},
+			{bounds.Min.X, bounds.Max.Y - 1},
+			{bounds.Max.X - 1, bounds.Max.Y - 1},
+		}
+		for i, p := range points {
+			if got := color.RGBAModel.Convert(img.At(p.X, p.Y)); got != tt.corners[i] {
+				t.Errorf("orientation %d: pixel %v = %v, want %v", tt.orientation, p, got, tt.corners[i])
+			}
+		}
+	}
+
+	// Values outside the specification leave the image alone
+	for _, orientation := range []int{0, 9, -1} {
+		if img := ApplyOrientation(src, orientation); img != image.Image(src) {
+			t.Errorf("orientation %d: image was transformed", orientation)
+		}
+	}
+}
+
+// exifTIFF builds a TIFF-structured EXIF payload, little-endian or not,
+// whose first IFD holds an unrelated tag and then the orientation, stored
+// with typ.
+func exifTIFF(littleEndian bool, typ uint16, orientation uint16) []byte {
+	var order binary.AppendByteOrder = binary.BigEndian
+	tiff := []byte("MM")
+	if littleEndian {
+		order, tiff = binary.LittleEndian, []byte("II")
+	}
+	tiff = order.AppendUint16(tiff, 42)
+	tiff = order.AppendUint32(tiff, 8)
+	tiff = order.AppendUint16(tiff, 2)
+
+	// ImageWidth, a SHORT that must not be mistaken for the orientation
+	tiff = order.AppendUint16(tiff, 0x0100)
+	tiff = order.AppendUint16(tiff, exifTypeShort)
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, 3)
+	tiff = order.AppendUint16(tiff, 0)
+
+	tiff = order.AppendUint16(tiff, exifTagOrientation)
+	tiff = order.AppendUint16(tiff, typ)
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, orientation)
+	tiff = order.AppendUint16(tiff, 0)
+	return order.AppendUint32(tiff, 0)
+}
+
+// jpegWith builds a JPEG holding the given segments before an empty scan.
+func jpegWith(segments ...[]byte) []byte {
+	data := []byte{0xFF, jpegMarkerSOI}
+	for _, segment := range segments {
+		data = append(data, segment...)
+	}
+	return append(data, 0xFF, jpegMarkerSOS, 0, 2, 0xFF, jpegMarkerEOI)
+}
+
+// jpegSegment encodes a marker segment with payload.
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(2+len(payload)))
+	return append(segment, payload...)
+}
+
+// app1 returns an EXIF APP1 segment holding tiff.
+func app1(tiff []byte) []byte {
+	return jpegSegment(jpegMarkerAPP1, append([]byte("Exif\x00\x00"), tiff...))
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	const be, le = false, true
+	app0 := jpegSegment(0xE0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))
+
+	truncated := app1(exifTIFF(be, exifTypeShort, OrientationRotate90))
+	truncated = truncated[:len(truncated)-10]
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"big-endian", jpegWith(app1(exifTIFF(be, exifTypeShort, OrientationRotate90))), OrientationRotate90},
+		{"little-endian", jpegWith(app1(exifTIFF(le, exifTypeShort, OrientationRotate270))), OrientationRotate270},
+		{"after APP0 and fill bytes", jpegWith(app0, []byte{0xFF}, app1(exifTIFF(le, exifTypeShort, OrientationFlipV))), OrientationFlipV},
+		{"no APP1", jpegWith(app0), OrientationNormal},
+		{"APP1 without EXIF", jpegWith(jpegSegment(jpegMarkerAPP1, []byte("http://ns.adobe.com/xap/1.0/\x00"))), OrientationNormal},
+		{"APP1 after the scan", append(jpegWith(), app1(exifTIFF(be, exifTypeShort, OrientationRotate90))...), OrientationNormal},
+		{"truncated APP1", append([]byte{0xFF, jpegMarkerSOI}, truncated...), OrientationNormal},
+		{"APP1 cut inside its header", []byte{0xFF, jpegMarkerSOI, 0xFF, jpegMarkerAPP1, 0x00}, OrientationNormal},
+		{"truncated TIFF", jpegWith(app1(exifTIFF(be, exifTypeShort, OrientationRotate90)[:20])), OrientationNormal},
+		{"bad byte order", jpegWith(app1(append([]byte("XX"), exifTIFF(be, exifTypeShort, OrientationRotate90)[2:]...))), OrientationNormal},
+		{"wrong type", jpegWith(app1(exifTIFF(be, 4, OrientationRotate90))), OrientationNormal},
+		{"out of range", jpegWith(app1(exifTIFF(le, exifTypeShort, 9))), OrientationNormal},
+		{"not a JPEG", []byte("\x89PNG\r\n\x1a\n"), OrientationNormal},
+		{"empty", nil, OrientationNormal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := JPEGOrientation(tt.data); got != tt.want {
+				t.Errorf("JPEGOrientation = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Image format names, matching the names used by image.RegisterFormat.
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatBMP  = "bmp"
+	FormatGIF  = "gif"
+	FormatTIFF = "tiff"
+	FormatWebP = "webp"
+	FormatPNM  = "pnm"
+	FormatICO  = "ico"
+)
+
+// sniffLen is the number of leading bytes DetectFormat needs.
+const sniffLen = 16
+
+// DetectFormat identifies an image format from its leading magic bytes. It
+// returns "" when the content does not match a supported format.
+func DetectFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return FormatJPEG
+	case bytes.HasPrefix(data, pngHeader):
+		return FormatPNG
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return FormatGIF
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return FormatTIFF
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return FormatWebP
+	case bytes.HasPrefix(data, []byte("BM")) && len(data) >= 14:
+		return FormatBMP
+	case len(data) >= 3 && data[0] == 'P' && data[1] >= '1' && data[1] <= '6' && isPNMSpace(data[2]):
+		return FormatPNM
+	case bytes.HasPrefix(data, []byte{0, 0, 1, 0}), bytes.HasPrefix(data, []byte{0, 0, 2, 0}):
+		return FormatICO
+	}
+	return ""
+}
+
+// FormatForExtension returns the format implied by the extension of path,
+// or "" for unknown extensions.
+func FormatForExtension(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		return FormatJPEG
+	case ".png":
+		return FormatPNG
+	case ".bmp":
+		return FormatBMP
+	case ".gif":
+		return FormatGIF
+	case ".tif", ".tiff":
+		return FormatTIFF
+	case ".webp":
+		return FormatWebP
+	case ".pnm", ".pbm", ".pgm", ".ppm":
+		return FormatPNM
+	case ".ico", ".cur":
+		return FormatICO
+	}
+	return ""
+}
+
+// SniffFile detects the format of the file at path from its content.
+func SniffFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return DetectFormat(header[:n]), nil
+}
+
+// ExtensionMismatch returns a warning when the content of the file at path
+// is a different format than its extension claims, or "" when they agree or
+// either side is unknown.
+func ExtensionMismatch(path string) string {
+	detected, err := SniffFile(path)
+	if err != nil || detected == "" {
+		return ""
+	}
+
+	expected := FormatForExtension(path)
+	if expected == "" || expected == detected {
+		return ""
+	}
+	return "content is " + strings.ToUpper(detected) + ", not " + strings.ToUpper(expected)
+}
@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// Windows icon (ICO) and cursor (CUR) decoding. Only the largest image in
+// the file is decoded.
+
+func init() {
+	image.RegisterFormat("ico", "\x00\x00\x01\x00", decodeICO, decodeICOConfig)
+	image.RegisterFormat("cur", "\x00\x00\x02\x00", decodeICO, decodeICOConfig)
+}
+
+type icoEntry struct {
+	width, height int
+	bitCount      int
+	data          []byte
+}
+
+// readICO returns the largest image entry of an icon or cursor file.
+func readICO(r io.Reader) (icoEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return icoEntry{}, err
+	}
+	if len(data) < 6 || binary.LittleEndian.Uint16(data[0:2]) != 0 {
+		return icoEntry{}, errors.New("ico: invalid format")
+	}
+
+	kind := binary.LittleEndian.Uint16(data[2:4])
+	count := int(binary.LittleEndian.Uint16(data[4:6]))
+	if (kind != 1 && kind != 2) || count == 0 || 6+count*16 > len(data) {
+		return icoEntry{}, errors.New("ico: invalid format")
+	}
+
+	var best icoEntry
+	for i := 0; i < count; i++ {
+		dir := data[6+i*16 : 6+(i+1)*16]
+		entry := icoEntry{width: int(dir[0]), height: int(dir[1])}
+		if entry.width == 0 {
+			entry.width = 256
+		}
+		if entry.height == 0 {
+			entry.height = 256
+		}
+		if kind == 1 {
+			// Cursors store the hotspot here instead
+			entry.bitCount = int(binary.LittleEndian.Uint16(dir[6:8]))
+		}
+
+		size := int(binary.LittleEndian.Uint32(dir[8:12]))
+		offset := int(binary.LittleEndian.Uint32(dir[12:16]))
+		if size <= 0 || offset < 0 || offset+size > len(data) {
+			continue
+		}
+		entry.data = data[offset : offset+size]
+
+		area, bestArea := entry.width*entry.height, best.width*best.height
+		if area > bestArea || (area == bestArea && entry.bitCount > best.bitCount) {
+			best = entry
+		}
+	}
+
+	if best.data == nil {
+		return icoEntry{}, errors.New("ico: no usable image")
+	}
+	return best, nil
+}
+
+func decodeICOConfig(r io.Reader) (image.Config, error) {
+	entry, err := readICO(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	if bytes.HasPrefix(entry.data, pngHeader) {
+		return png.DecodeConfig(bytes.NewReader(entry.data))
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: entry.width, Height: entry.height}, nil
+}
+
+func decodeICO(r io.Reader) (image.Image, error) {
+	entry, err := readICO(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(entry.data, pngHeader) {
+		return png.Decode(bytes.NewReader(entry.data))
+	}
+	return decodeICODIB(entry.data)
+}
+
+// decodeICODIB decodes a device-independent bitmap as stored in icons: a
+// BITMAPINFOHEADER with doubled height, an optional palette, the bottom-up
+// colour rows and then a 1-bit transparency (AND) mask.
+func decodeICODIB(data []byte) (image.Image, error) {
+	if len(data) < 40 {
+		return nil, errors.New("ico: truncated bitmap header")
+	}
+
+	le := binary.LittleEndian
+	headerSize := int(le.Uint32(data[0:4]))
+	width := int(int32(le.Uint32(data[4:8])))
+	height := int(int32(le.Uint32(data[8:12]))) / 2
+	bpp := int(le.Uint16(data[14:16]))
+	compression := le.Uint32(data[16:20])
+	colorsUsed := int(le.Uint32(data[32:36]))
+
+	if width <= 0 || height <= 0 || width > 1024 || height > 1024 {
+		return nil, fmt.Errorf("ico: invalid bitmap dimensions %dx%d", width, height)
+	}
+	if compression != 0 {
+		return nil, errors.New("ico: compressed bitmaps are not supported")
+	}
+
+	var palette []color.NRGBA
+	switch bpp {
+	case 1, 4, 8:
+		if colorsUsed == 0 || colorsUsed > 1<<bpp {
+			colorsUsed = 1 << bpp
+		}
+		start := headerSize
+		if start < 40 || start+colorsUsed*4 > len(data) {
+			return nil, errors.New("ico: truncated palette")
+		}
+		palette = make([]color.NRGBA, colorsUsed)
+		for i := range palette {
+			c := data[start+i*4:]
+			palette[i] = color.NRGBA{R: c[2], G: c[1], B: c[0], A: 0xFF}
+		}
+	case 24, 32:
+	default:
+		return nil, fmt.Errorf("ico: unsupported bit depth %d", bpp)
+	}
+
+	pixelStart := headerSize + len(palette)*4
+	stride := (width*bpp + 31) / 32 * 4
+	maskStart := pixelStart + stride*height
+	maskStride := (width + 31) / 32 * 4
+	if maskStart > len(data) {
+		return nil, errors.New("ico: truncated bitmap")
+	}
+	hasMask := maskStart+maskStride*height <= len(data)
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	hasAlpha := false
+	for y := 0; y < height; y++ {
+		row := data[pixelStart+(height-1-y)*stride:]
+		for x := 0; x < width; x++ {
+			var c color.NRGBA
+			switch bpp {
+			case 1, 4, 8:
+				bit := x * bpp
+				index := int(row[bit/8]>>(8-bpp-bit%8)) & (1<<bpp - 1)
+				if index < len(palette) {
+					c = palette[index]
+				}
+			case 24:
+				c = color.NRGBA{R: row[x*3+2], G: row[x*3+1], B: row[x*3], A: 0xFF}
+			case 32:
+				c = color.NRGBA{R: row[x*4+2], G: row[x*4+1], B: row[x*4], A: row[x*4+3]}
+				hasAlpha = hasAlpha || c.A != 0
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	// 32-bit icons carry their own alpha; older ones rely on the AND mask
+	if hasMask && !hasAlpha {
+		for y := 0; y < height; y++ {
+			row := data[maskStart+(height-1-y)*maskStride:]
+			for x := 0; x < width; x++ {
+				i := img.PixOffset(x, y) + 3
+				if row[x/8]&(0x80>>(x%8)) != 0 {
+					img.Pix[i] = 0
+				} else {
+					img.Pix[i] = 0xFF
+				}
+			}
+		}
+	}
+
+	return img, nil
+}
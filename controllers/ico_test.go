@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// icoImage is one image of a test icon file.
+type icoImage struct {
+	width, height int
+	bpp           int
+	data          []byte
+}
+
+// buildICO assembles an icon (kind 1) or cursor (kind 2) file holding
+// images, stored in order after the directory.
+func buildICO(kind uint16, images ...icoImage) []byte {
+	le := binary.LittleEndian
+	out := le.AppendUint16(nil, 0)
+	out = le.AppendUint16(out, kind)
+	out = le.AppendUint16(out, uint16(len(images)))
+
+	offset := 6 + 16*len(images)
+	for _, img := range images {
+		out = append(out, byte(img.width), byte(img.height), 0, 0)
+		out = le.AppendUint16(out, 1)
+		out = le.AppendUint16(out, uint16(img.bpp))
+		out = le.AppendUint32(out, uint32(len(img.data)))
+		out = le.AppendUint32(out, uint32(offset))
+		offset += len(img.data)
+	}
+	for _, img := range images {
+		out = append(out, img.data...)
+	}
+	return out
+}
+
+// buildDIB assembles an icon bitmap. rows holds the packed pixels of each
+// row, top first, and mask the AND mask bits of each row, or nil for none.
+func buildDIB(width, height, bpp int, palette []color.NRGBA, rows, mask [][]byte) []byte {
+	le := binary.LittleEndian
+	out := le.AppendUint32(nil, 40)
+	out = le.AppendUint32(out, uint32(width))
+	out = le.AppendUint32(out, uint32(height*2))
+	out = le.AppendUint16(out, 1)
+	out = le.AppendUint16(out, uint16(bpp))
+	out = append(out, make([]byte, 12)...) // compression, image size, resolution
+	out = append(out, make([]byte, 4)...)
+	out = le.AppendUint32(out, uint32(len(palette)))
+	out = le.AppendUint32(out, 0)
+
+	for _, c := range palette {
+		out = append(out, c.B, c.G, c.R, 0)
+	}
+
+	// Rows are stored bottom-up, padded to four bytes
+	appendRows := func(rows [][]byte, stride int) {
+		for y := len(rows) - 1; y >= 0; y-- {
+			row := make([]byte, stride)
+			copy(row, rows[y])
+			out = append(out, row...)
+		}
+	}
+	appendRows(rows, (width*bpp+31)/32*4)
+	if mask != nil {
+		appendRows(mask, (width+31)/32*4)
+	}
+	return out
+}
+
+func TestDecodeICO(t *testing.T) {
+	black := color.NRGBA{A: 0xFF}
+	white := color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
+	red := color.NRGBA{R: 0xFF, A: 0xFF}
+	green := color.NRGBA{G: 0xFF, A: 0xFF}
+	blue := color.NRGBA{B: 0xFF, A: 0xFF}
+	clear := color.NRGBA{}
+
+	var pngData bytes.Buffer
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	src.SetNRGBA(0, 0, red)
+	src.SetNRGBA(2, 1, color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0x80})
+	if err := png.Encode(&pngData, src); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		w, h int
+		// want lists the pixels row by row
+		want []color.NRGBA
+	}{
+		{
+			name: "PNG image",
+			data: buildICO(1, icoImage{width: 3, height: 2, bpp: 32, data: pngData.Bytes()}),
+			w:    3, h: 2,
+			want: []color.NRGBA{red, clear, clear, clear, clear, {R: 0x10, G: 0x20, B: 0x30, A: 0x80}},
+		},
+		{
+			name: "1-bpp with AND mask",
+			data: buildICO(1, icoImage{width: 2, height: 2, bpp: 1, data: buildDIB(2, 2, 1,
+				[]color.NRGBA{black, white},
+				[][]byte{{0x40}, {0x80}},
+				[][]byte{{0x00}, {0x40}})}),
+			w: 2, h: 2,
+			want: []color.NRGBA{black, white, white, {A: 0}},
+		},
+		{
+			name: "4-bpp with short palette",
+			data: buildICO(1, icoImage{width: 2, height: 2, bpp: 4, data: buildDIB(2, 2, 4,
+				[]color.NRGBA{red, green, blue},
+				[][]byte{{0x01}, {0x20}},
+				[][]byte{{0x80}, {0x00}})}),
+			w: 2, h: 2,
+			want: []color.NRGBA{{R: 0xFF}, green, blue, red},
+		},
+		{
+			name: "8-bpp",
+			data: buildICO(1, icoImage{width: 2, height: 2, bpp: 8, data: buildDIB(2, 2, 8,
+				[]color.NRGBA{green, blue},
+				[][]byte{{1, 0}, {0, 1}},
+				[][]byte{{0x00}, {0x00}})}),
+			w: 2, h: 2,
+			want: []color.NRGBA{blue, green, green, blue},
+		},
+		{
+			name: "24-bpp with row padding",
+			data: buildICO(1, icoImage{width: 3, height: 1, bpp: 24, data: buildDIB(3, 1, 24, nil,
+				[][]byte{{0xFF, 0, 0, 0, 0xFF, 0, 0x30, 0x20, 0x10}},
+				[][]byte{{0x20}})}),
+			w: 3, h: 1,
+			want: []color.NRGBA{blue, green, {R: 0x10, G: 0x20, B: 0x30}},
+		},
+		{
+			name: "32-bpp alpha overrides the mask",
+			data: buildICO(1, icoImage{width: 2, height: 1, bpp: 32, data: buildDIB(2, 1, 32, nil,
+				[][]byte{{0, 0, 0xFF, 0xFF, 0xFF, 0, 0, 0x40}},
+				[][]byte{{0xC0}})}),
+			w: 2, h: 1,
+			want: []color.NRGBA{red, {B: 0xFF, A: 0x40}},
+		},
+		{
+			name: "32-bpp without alpha uses the mask",
+			data: buildICO(1, icoImage{width: 2, height: 1, bpp: 32, data: buildDIB(2, 1, 32, nil,
+				[][]byte{{0, 0, 0xFF, 0, 0xFF, 0, 0, 0}},
+				[][]byte{{0x40}})}),
+			w: 2, h: 1,
+			want: []color.NRGBA{red, {B: 0xFF}},
+		},
+		{
+			name: "24-bpp without mask",
+			data: buildICO(1, icoImage{width: 1, height: 1, bpp: 24, data: buildDIB(1, 1, 24, nil,
+				[][]byte{{0, 0xFF, 0}}, nil)}),
+			w: 1, h: 1,
+			want: []color.NRGBA{green},
+		},
+		{
+			name: "largest image is chosen",
+			data: buildICO(1,
+				icoImage{width: 1, height: 1, bpp: 24, data: buildDIB(1, 1, 24, nil, [][]byte{{0, 0, 0xFF}}, nil)},
+				icoImage{width: 2, height: 1, bpp: 24, data: buildDIB(2, 1, 24, nil, [][]byte{{0xFF, 0, 0, 0xFF, 0, 0}}, nil)},
+				icoImage{width: 1, height: 2, bpp: 24, data: buildDIB(1, 2, 24, nil, [][]byte{{0, 0xFF, 0}, {0, 0xFF, 0}}, nil)},
+			),
+			w: 2, h: 1,
+			want: []color.NRGBA{blue, blue},
+		},
+		{
+			name: "cursor",
+			data: buildICO(2, icoImage{width: 1, height: 1, data: buildDIB(1, 1, 24, nil, [][]byte{{0, 0, 0xFF}}, nil)}),
+			w:    1, h: 1,
+			want: []color.NRGBA{red},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := decodeICOConfig(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("decodeICOConfig: %v", err)
+			}
+			if config.Width != tt.w || config.Height != tt.h {
+				t.Errorf("config = %dx%d, want %dx%d", config.Width, config.Height, tt.w, tt.h)
+			}
+
+			img, err := decodeICO(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("decodeICO: %v", err)
+			}
+			if got := img.Bounds(); got != image.Rect(0, 0, tt.w, tt.h) {
+				t.Fatalf("bounds = %v, want %dx%d", got, tt.w, tt.h)
+			}
+			for i, want := range tt.want {
+				x, y := i%tt.w, i/tt.w
+				if got := color.NRGBAModel.Convert(img.At(x, y)); got != want {
+					t.Errorf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeICOMalformed(t *testing.T) {
+	le := binary.LittleEndian
+	dib := buildDIB(1, 1, 24, nil, [][]byte{{0, 0, 0xFF}}, nil)
+	valid := buildICO(1, icoImage{width: 1, height: 1, bpp: 24, data: dib})
+
+	// patch returns valid with the directory entry field at off replaced
+	patch := func(off int, v uint32) []byte {
+		data := bytes.Clone(valid)
+		le.PutUint32(data[off:], v)
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", valid[:4]},
+		{"bad reserved field", append([]byte{1}, valid[1:]...)},
+		{"unknown type", append([]byte{0, 0, 3}, valid[3:]...)},
+		{"no images", []byte{0, 0, 1, 0, 0, 0}},
+		{"directory past the end", valid[:6+8]},
+		{"offset past the end", patch(6+12, uint32(len(valid)))},
+		{"offset overflows", patch(6+12, 0xFFFFFFF0)},
+		{"size past the end", patch(6+8, uint32(len(dib)+1))},
+		{"zero size", patch(6+8, 0)},
+		{"truncated bitmap header", buildICO(1, icoImage{width: 1, height: 1, data: dib[:20]})},
+		{"truncated pixels", buildICO(1, icoImage{width: 1, height: 1, data: dib[:41]})},
+		{"truncated palette", buildICO(1, icoImage{width: 2, height: 1, data: buildDIB(2, 1, 8, make([]color.NRGBA, 4), [][]byte{{0, 1}}, nil)[:44]})},
+		{"unsupported depth", buildICO(1, icoImage{width: 1, height: 1, data: buildDIB(1, 1, 16, nil, [][]byte{{0, 0}}, nil)})},
+		{"zero width", buildICO(1, icoImage{width: 1, height: 1, data: buildDIB(0, 1, 24, nil, nil, nil)})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if img, err := decodeICO(bytes.NewReader(tt.data)); err == nil {
+				t.Errorf("decodeICO succeeded with %v", img.Bounds())
+			}
+		})
+	}
+
+	// A broken entry is passed over for a usable one
+	broken := buildICO(1,
+		icoImage{width: 4, height: 4, bpp: 32, data: dib},
+		icoImage{width: 1, height: 1, bpp: 24, data: dib},
+	)
+	le.PutUint32(broken[6+12:], uint32(len(broken)+100))
+	img, err := decodeICO(bytes.NewReader(broken))
+	if err != nil {
+		t.Fatalf("decodeICO with one broken entry: %v", err)
+	}
+	if got := img.Bounds(); got != image.Rect(0, 0, 1, 1) {
+		t.Errorf("bounds = %v, want 1x1", got)
+	}
+}
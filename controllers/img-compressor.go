@@ -1,35 +1,78 @@
+// Package controllers implements the GUI-independent image conversion used by
+// the desktop app and the convert command.
 package controllers
 
 import (
+	"bytes"
 	"image"
+	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"io"
-	"path/filepath"
-	"strings"
 
+	"github.com/chai2010/webp"
 	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
 )
 
+// SupportedExtensions lists the image file extensions (without the leading
+// dot) that DecodeImage handles explicitly.
+var SupportedExtensions = []string{
+	"jpg", "jpeg", "png", "bmp", "gif", "tif", "tiff", "webp",
+	"pnm", "pbm", "pgm", "ppm", "ico", "cur",
+}
+
+// IsSupportedImage reports whether path has one of the SupportedExtensions.
+func IsSupportedImage(path string) bool {
+	return FormatForExtension(path) != ""
+}
+
+// DecodeImage decodes an image, choosing the decoder from the content's
+// magic bytes and falling back to the extension of inputPath only when the
+// content is not recognised. JPEGs are rotated and flipped according to
+// their EXIF orientation.
 func DecodeImage(file io.Reader, inputPath string) (*image.Image, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
 	var img image.Image
-	var err error
-	ext := strings.ToLower(filepath.Ext(inputPath))
-
-	switch ext {
-	case ".jpg", ".jpeg":
-		img, err = jpeg.Decode(file)
-	case ".png":
-		img, err = png.Decode(file)
-	case ".bmp":
-		img, err = bmp.Decode(file)
+	format := DetectFormat(data)
+	if format == "" {
+		format = FormatForExtension(inputPath)
+	}
+	r := bytes.NewReader(data)
+
+	switch format {
+	case FormatJPEG:
+		img, err = jpeg.Decode(r)
+	case FormatPNG:
+		img, err = png.Decode(r)
+	case FormatBMP:
+		img, err = bmp.Decode(r)
+	case FormatGIF:
+		// Animated GIFs are reduced to their first frame
+		img, err = gif.Decode(r)
+	case FormatTIFF:
+		img, err = tiff.Decode(r)
+	case FormatWebP:
+		img, err = webp.Decode(r)
+	case FormatPNM:
+		img, err = decodePNM(r)
+	case FormatICO:
+		img, err = decodeICO(r)
 	default:
-		img, _, err = image.Decode(file)
+		img, format, err = image.Decode(r)
 	}
 
 	if err != nil {
 		return nil, err
 	}
 
+	if format == FormatJPEG {
+		img = ApplyOrientation(img, JPEGOrientation(data))
+	}
+
 	return &img, nil
 }
@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// IncrementalMode decides when a conversion is skipped because an earlier
+// run already produced its output.
+type IncrementalMode string
+
+const (
+	// IncrementalOff converts every file. It is the default.
+	IncrementalOff IncrementalMode = "off"
+	// IncrementalNewer skips a file when its output exists and is at least
+	// as new as the source.
+	IncrementalNewer IncrementalMode = "newer"
+	// IncrementalStrict skips a file only when the manifest shows it was
+	// converted from identical content with identical settings and the
+	// output still exists.
+	IncrementalStrict IncrementalMode = "strict"
+)
+
+// IncrementalModes lists every IncrementalMode in display order.
+var IncrementalModes = []IncrementalMode{IncrementalOff, IncrementalNewer, IncrementalStrict}
+
+// ManifestName is the file name of the manifest kept for IncrementalStrict.
+const ManifestName = ".image-compressor-manifest.json"
+
+// ManifestPath returns where the manifest for a batch is kept: in the
+// output directory, or in the folder the inputs share when writing next to
+// the originals.
+func ManifestPath(layout OutputLayout, inputs []string) string {
+	dir := layout.Dir
+	if dir == "" {
+		dir = CommonDir(inputs)
+	}
+	return filepath.Join(dir, ManifestName)
+}
+
+// Manifest records the content hash and settings each source was last
+// converted with. It is safe for concurrent use.
+type Manifest struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]manifestEntry
+	changed bool
+}
+
+type manifestEntry struct {
+	Hash     string `json:"hash"`
+	Settings string `json:"settings"`
+
+	// Target is the output path before collision handling and Output the
+	// file actually written, which is empty when opts.Larger is LargerSkip
+	// and the WebP came out larger.
+	Target string `json:"target"`
+	Output string `json:"output"`
+}
+
+// LoadManifest reads the manifest at path. A missing file yields an empty
+// manifest that Save creates.
+func LoadManifest(path string) (*Manifest, error) {
+	m := &Manifest{path: path, entries: make(map[string]manifestEntry)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &m.entries); err != nil {
+		return nil, fmt.Errorf("reading manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Save writes the manifest back if anything was recorded since it was
+// loaded.
+func (m *Manifest) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.changed {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(m.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	file, err := createTemp(m.path)
+	if err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), m.path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	m.changed = false
+	return nil
+}
+
+// lookup returns the output recorded for inputPath if it was converted to
+// target from data with opts and still exists. The output is empty when the
+// conversion was skipped because the WebP came out larger.
+func (m *Manifest) lookup(inputPath, target string, data []byte, opts Options) (string, bool) {
+	key, err := filepath.Abs(inputPath)
+	if err != nil {
+		return "", false
+	}
+
+	m.mu.Lock()
+	entry, ok := m.entries[key]
+	m.mu.Unlock()
+
+	if !ok || entry.Target != target || entry.Settings != opts.Fingerprint() || (entry.Output != "" && !outputExists(entry.Output)) {
+		return "", false
+	}
+	if entry.Hash != contentHash(data) {
+		return "", false
+	}
+	return entry.Output, true
+}
+
+// record notes that inputPath was converted to output from data with opts.
+// An empty output records a conversion skipped by LargerSkip.
+func (m *Manifest) record(inputPath, target, output string, data []byte, opts Options) {
+	key, err := filepath.Abs(inputPath)
+	if err != nil {
+		return
+	}
+
+	entry := manifestEntry{
+		Hash:     contentHash(data),
+		Settings: opts.Fingerprint(),
+		Target:   target,
+		Output:   output,
+	}
+
+	m.mu.Lock()
+	m.entries[key] = entry
+	m.changed = true
+	m.mu.Unlock()
+}
+
+// recordOutcome notes in the manifest, under IncrementalStrict, that
+// inputPath was converted to output, or to nothing when output is empty.
+func (o Options) recordOutcome(inputPath, target, output string, data []byte) {
+	if o.Incremental == IncrementalStrict {
+		o.Manifest.record(inputPath, target, output, data, o)
+	}
+}
+
+// upToDate reports whether the output of an earlier run can be kept for
+// inputPath, and returns its path. The path is empty when the earlier run
+// wrote nothing because the WebP came out larger.
+//
+// IncrementalNewer also accepts the copy LargerCopy makes of a source that
+// does not shrink. It cannot tell whether a source copied onto itself was
+// converted before, so such sources are encoded again; IncrementalStrict
+// remembers them.
+func (o Options) upToDate(inputPath, outputPath string, info os.FileInfo, data []byte) (string, bool) {
+	switch o.Incremental {
+	case IncrementalNewer:
+		if isNewer(outputPath, info) {
+			return outputPath, true
+		}
+		if o.Larger == LargerCopy {
+			copyPath := originalPath(outputPath, inputPath)
+			if !sameFile(copyPath, inputPath) && isNewer(copyPath, info) {
+				return copyPath, true
+			}
+		}
+		return "", false
+	case IncrementalStrict:
+		return o.Manifest.lookup(inputPath, outputPath, data, o)
+	}
+	return "", false
+}
+
+// isNewer reports whether path exists and is at least as new as the source
+// described by info.
+func isNewer(path string, info os.FileInfo) bool {
+	out, err := os.Stat(path)
+	return err == nil && !out.ModTime().Before(info.ModTime())
+}
+
+// Fingerprint summarises the settings that affect the file written. It
+// leaves out Collision, Incremental and Manifest, which only decide whether
+// and where the file is written.
+func (o Options) Fingerprint() string {
+	larger := o.Larger
+	if larger == "" {
+		larger = LargerWrite
+	}
+	return fmt.Sprintf("quality=%g target=%d ssim=%g lossless=%t exact=%t resize=%dx%d/%s/%s metadata=%s larger=%s",
+		o.Quality, o.TargetSize, o.MinSSIM, o.Lossless, o.Exact,
+		o.Resize.MaxWidth, o.Resize.MaxHeight, o.Resize.Mode, o.Resize.Resampler,
+		o.Metadata, larger)
+}
+
+// contentHash returns the hex SHA-256 of data.
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
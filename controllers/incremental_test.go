@@ -0,0 +1,301 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeLargerSource writes a heavily compressed JPEG to path, whose WebP at
+// quality 100 comes out larger than the JPEG itself.
+func writeLargerSource(t *testing.T, path string) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	seed := uint32(1)
+	for i := range img.Pix {
+		seed = seed*1664525 + 1013904223
+		img.Pix[i] = uint8(seed >> 24)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	backdate(t, path)
+}
+
+// TestIncrementalLarger checks that sources whose WebP comes out larger are
+// not encoded again on every incremental run.
+func TestIncrementalLarger(t *testing.T) {
+	ctx := context.Background()
+	c := NewConverter()
+
+	tests := []struct {
+		name        string
+		incremental IncrementalMode
+		larger      LargerPolicy
+		// outDir writes to a separate directory instead of next to the
+		// source
+		outDir bool
+		// want is the output reported by the second run, relative to the
+		// test directory; empty when nothing was written
+		want string
+	}{
+		{"newer/copy", IncrementalNewer, LargerCopy, true, "out/photo.jpg"},
+		{"strict/copy", IncrementalStrict, LargerCopy, true, "out/photo.jpg"},
+		{"strict/copy onto source", IncrementalStrict, LargerCopy, false, "photo.jpg"},
+		{"strict/skip", IncrementalStrict, LargerSkip, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "photo.jpg")
+			writeLargerSource(t, input)
+			outDir := dir
+			if tt.outDir {
+				outDir = filepath.Join(dir, "out")
+			}
+
+			opts := Options{Quality: 100, Larger: tt.larger, Incremental: tt.incremental}
+			if tt.incremental == IncrementalStrict {
+				manifest, err := LoadManifest(filepath.Join(dir, ManifestName))
+				if err != nil {
+					t.Fatal(err)
+				}
+				opts.Manifest = manifest
+			}
+
+			first, err := c.ConvertFile(ctx, input, outDir, "{name}.webp", opts)
+			if err != nil || !first.Larger || first.UpToDate {
+				t.Fatalf("first run: larger = %v, up to date = %v, err = %v", first.Larger, first.UpToDate, err)
+			}
+
+			second, err := c.ConvertFile(ctx, input, outDir, "{name}.webp", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := ""
+			if tt.want != "" {
+				want = filepath.Join(dir, filepath.FromSlash(tt.want))
+			}
+			if !second.UpToDate || second.OutputPath != want {
+				t.Errorf("second run: up to date = %v, output %q; want up to date with %q", second.UpToDate, second.OutputPath, want)
+			}
+			if tt.larger == LargerSkip && !second.Larger {
+				t.Errorf("second run does not report the larger WebP")
+			}
+
+			// Another policy for larger outputs converts again
+			opts.Larger = LargerWrite
+			third, err := c.ConvertFile(ctx, input, outDir, "{name}.webp", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.incremental == IncrementalStrict && third.UpToDate {
+				t.Errorf("changing the larger-output policy kept the earlier outcome")
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	base := Options{Quality: 80, Metadata: MetadataStrip, Resize: ResizeOptions{Mode: ResizeFit, Resampler: ResampleCatmullRom}}
+	tests := []struct {
+		name    string
+		change  func(*Options)
+		changes bool
+	}{
+		{"quality", func(o *Options) { o.Quality = 81 }, true},
+		{"target size", func(o *Options) { o.TargetSize = 5000 }, true},
+		{"min SSIM", func(o *Options) { o.MinSSIM = 0.95 }, true},
+		{"lossless", func(o *Options) { o.Lossless = true }, true},
+		{"exact", func(o *Options) { o.Exact = true }, true},
+		{"resize width", func(o *Options) { o.Resize.MaxWidth = 800 }, true},
+		{"resize height", func(o *Options) { o.Resize.MaxHeight = 600 }, true},
+		{"resize mode", func(o *Options) { o.Resize.Mode = ResizeFill }, true},
+		{"resampler", func(o *Options) { o.Resize.Resampler = ResampleBilinear }, true},
+		{"metadata", func(o *Options) { o.Metadata = MetadataKeep }, true},
+		{"larger", func(o *Options) { o.Larger = LargerCopy }, true},
+		{"default larger", func(o *Options) { o.Larger = LargerWrite }, false},
+		{"collision", func(o *Options) { o.Collision = CollisionSuffix }, false},
+		{"incremental", func(o *Options) { o.Incremental = IncrementalStrict }, false},
+		{"manifest", func(o *Options) { o.Manifest = &Manifest{} }, false},
+	}
+	for _, tt := range tests {
+		changed := base
+		tt.change(&changed)
+		if got := changed.Fingerprint() != base.Fingerprint(); got != tt.changes {
+			t.Errorf("%s: fingerprint changed = %v, want %v", tt.name, got, tt.changes)
+		}
+	}
+}
+
+// TestUpToDate checks which earlier outputs each incremental mode accepts.
+// Every case starts from an earlier run that wrote the file named by
+// earlier from a backdated source.
+func TestUpToDate(t *testing.T) {
+	source := []byte("source v1")
+	tests := []struct {
+		name   string
+		mode   IncrementalMode
+		larger LargerPolicy
+		// earlier is the file the earlier run wrote, relative to the
+		// test directory; empty when it skipped a larger WebP
+		earlier string
+		// change is applied between the runs
+		change func(t *testing.T, dir string, opts *Options)
+		// want is the output accepted, relative to the test directory
+		want string
+		ok   bool
+	}{
+		{"off", IncrementalOff, "", "out/photo.webp", nil, "", false},
+
+		{"newer/unchanged", IncrementalNewer, "", "out/photo.webp", nil, "out/photo.webp", true},
+		{"newer/source edited", IncrementalNewer, "", "out/photo.webp", editSource, "", false},
+		{"newer/source touched", IncrementalNewer, "", "out/photo.webp", touchSource, "", false},
+		{"newer/source replaced by older file", IncrementalNewer, "", "out/photo.webp", replaceSourceWithOlder, "out/photo.webp", true},
+		{"newer/settings changed", IncrementalNewer, "", "out/photo.webp", raiseQuality, "out/photo.webp", true},
+		{"newer/output deleted", IncrementalNewer, "", "out/photo.webp", deleteOutput, "", false},
+		{"newer/output moved", IncrementalNewer, "", "out/photo.webp", moveOutput, "", false},
+		{"newer/copied", IncrementalNewer, LargerCopy, "out/photo.jpg", nil, "out/photo.jpg", true},
+		{"newer/copied, now writing", IncrementalNewer, LargerCopy, "out/photo.jpg", writeLarger, "", false},
+		{"newer/skipped", IncrementalNewer, LargerSkip, "", nil, "", false},
+
+		{"strict/unchanged", IncrementalStrict, "", "out/photo.webp", nil, "out/photo.webp", true},
+		{"strict/source edited", IncrementalStrict, "", "out/photo.webp", editSource, "", false},
+		{"strict/source touched", IncrementalStrict, "", "out/photo.webp", touchSource, "out/photo.webp", true},
+		{"strict/source replaced by older file", IncrementalStrict, "", "out/photo.webp", replaceSourceWithOlder, "", false},
+		{"strict/settings changed", IncrementalStrict, "", "out/photo.webp", raiseQuality, "", false},
+		{"strict/output deleted", IncrementalStrict, "", "out/photo.webp", deleteOutput, "", false},
+		{"strict/output moved", IncrementalStrict, "", "out/photo.webp", moveOutput, "", false},
+		{"strict/copied", IncrementalStrict, LargerCopy, "out/photo.jpg", nil, "out/photo.jpg", true},
+		{"strict/copied, now writing", IncrementalStrict, LargerCopy, "out/photo.jpg", writeLarger, "", false},
+		{"strict/skipped", IncrementalStrict, LargerSkip, "", nil, "", true},
+		{"strict/skipped, source edited", IncrementalStrict, LargerSkip, "", editSource, "", false},
+		{"strict/skipped, settings changed", IncrementalStrict, LargerSkip, "", raiseQuality, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "photo.jpg")
+			target := filepath.Join(dir, "out", "photo.webp")
+			if err := os.Mkdir(filepath.Join(dir, "out"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(input, source, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			backdate(t, input)
+
+			manifestPath := filepath.Join(dir, "out", ManifestName)
+			opts := Options{Quality: 80, Larger: tt.larger, Incremental: tt.mode}
+			if tt.mode == IncrementalStrict {
+				manifest, err := LoadManifest(manifestPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				opts.Manifest = manifest
+			}
+
+			// The earlier run
+			earlier := ""
+			if tt.earlier != "" {
+				earlier = filepath.Join(dir, filepath.FromSlash(tt.earlier))
+				if err := os.WriteFile(earlier, []byte("output"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				// Between the source and any later edit
+				written := time.Now().Add(-time.Minute)
+				if err := os.Chtimes(earlier, written, written); err != nil {
+					t.Fatal(err)
+				}
+			}
+			opts.recordOutcome(input, target, earlier, source)
+			if opts.Manifest != nil {
+				if err := opts.Manifest.Save(); err != nil {
+					t.Fatal(err)
+				}
+				manifest, err := LoadManifest(manifestPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				opts.Manifest = manifest
+			}
+
+			if tt.change != nil {
+				tt.change(t, dir, &opts)
+			}
+			info, err := os.Stat(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := opts.upToDate(input, target, info, data)
+			want := ""
+			if tt.want != "" {
+				want = filepath.Join(dir, filepath.FromSlash(tt.want))
+			}
+			if got != want || ok != tt.ok {
+				t.Errorf("upToDate = %q, %v; want %q, %v", got, ok, want, tt.ok)
+			}
+		})
+	}
+}
+
+// editSource gives the source new content and a new modification time.
+func editSource(t *testing.T, dir string, opts *Options) {
+	if err := os.WriteFile(filepath.Join(dir, "photo.jpg"), []byte("source v2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// touchSource gives the source a new modification time only.
+func touchSource(t *testing.T, dir string, opts *Options) {
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(dir, "photo.jpg"), now, now); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// replaceSourceWithOlder gives the source new content but an old
+// modification time, as restoring a backup does.
+func replaceSourceWithOlder(t *testing.T, dir string, opts *Options) {
+	path := filepath.Join(dir, "photo.jpg")
+	if err := os.WriteFile(path, []byte("source v0"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	backdate(t, path)
+}
+
+func raiseQuality(t *testing.T, dir string, opts *Options) {
+	opts.Quality = 90
+}
+
+func writeLarger(t *testing.T, dir string, opts *Options) {
+	opts.Larger = LargerWrite
+}
+
+func deleteOutput(t *testing.T, dir string, opts *Options) {
+	if err := os.Remove(filepath.Join(dir, "out", "photo.webp")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func moveOutput(t *testing.T, dir string, opts *Options) {
+	if err := os.Rename(filepath.Join(dir, "out", "photo.webp"), filepath.Join(dir, "photo.webp")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LargerPolicy decides what happens when the WebP output is bigger than the
+// source file.
+type LargerPolicy string
+
+const (
+	// LargerWrite writes the WebP anyway and flags it in the Report. It is
+	// the default.
+	LargerWrite LargerPolicy = "write"
+	// LargerSkip writes nothing.
+	LargerSkip LargerPolicy = "skip"
+	// LargerCopy copies the original to the output location, keeping its
+	// extension.
+	LargerCopy LargerPolicy = "copy"
+)
+
+// LargerPolicies lists every LargerPolicy in display order.
+var LargerPolicies = []LargerPolicy{LargerWrite, LargerSkip, LargerCopy}
+
+// originalPath swaps the extension of outputPath for the one of inputPath.
+func originalPath(outputPath, inputPath string) string {
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + filepath.Ext(inputPath)
+}
+
+// sameFile reports whether both paths name the same existing file.
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	return err == nil && os.SameFile(infoA, infoB)
+}
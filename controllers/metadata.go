@@ -0,0 +1,337 @@
+package controllers
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/chai2010/webp"
+)
+
+// MetadataPolicy selects which source metadata is written to the WebP output.
+type MetadataPolicy string
+
+const (
+	// MetadataStrip drops all metadata. It is the default.
+	MetadataStrip MetadataPolicy = "strip"
+	// MetadataKeep carries EXIF, XMP and ICC profiles over unchanged, except
+	// that the EXIF orientation is reset because the pixels are already
+	// upright. An author or copyright from PNG text chunks is written as a
+	// minimal EXIF block when the source has no EXIF.
+	MetadataKeep MetadataPolicy = "keep"
+	// MetadataCopyright keeps the ICC profile plus the author and copyright
+	// notice, which are written as a minimal EXIF block. Everything else,
+	// including GPS data and XMP, is dropped.
+	MetadataCopyright MetadataPolicy = "copyright"
+)
+
+// MetadataPolicies lists every MetadataPolicy in display order.
+var MetadataPolicies = []MetadataPolicy{MetadataStrip, MetadataKeep, MetadataCopyright}
+
+const (
+	exifTagArtist    = 0x013B
+	exifTagCopyright = 0x8298
+	exifTypeASCII    = 2
+
+	jpegMarkerAPP2 = 0xE2
+
+	pngXMPKeyword = "XML:com.adobe.xmp"
+)
+
+var (
+	xmpHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iccHeader = []byte("ICC_PROFILE\x00")
+	pngHeader = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// Metadata holds the metadata blocks read from a source image.
+type Metadata struct {
+	// EXIF is TIFF-structured, without the JPEG "Exif\0\0" prefix.
+	EXIF []byte
+	ICC  []byte
+	XMP  []byte
+
+	// Artist and Copyright are read from EXIF or, for PNG, from text chunks.
+	Artist    string
+	Copyright string
+}
+
+// IsEmpty reports whether there is no metadata block to write.
+func (m Metadata) IsEmpty() bool {
+	return len(m.EXIF) == 0 && len(m.ICC) == 0 && len(m.XMP) == 0
+}
+
+// ExtractMetadata reads the metadata of JPEG, PNG or WebP data. Other
+// formats and malformed metadata yield an empty result.
+func ExtractMetadata(data []byte) Metadata {
+	var m Metadata
+	switch DetectFormat(data) {
+	case FormatJPEG:
+		m = jpegMetadata(data)
+	case FormatPNG:
+		m = pngMetadata(data)
+	case FormatWebP:
+		m.EXIF, _ = webp.GetMetadata(data, "EXIF")
+		m.ICC, _ = webp.GetMetadata(data, "ICCP")
+		m.XMP, _ = webp.GetMetadata(data, "XMP")
+	}
+
+	if artist := exifASCII(m.EXIF, exifTagArtist); artist != "" {
+		m.Artist = artist
+	}
+	if copyright := exifASCII(m.EXIF, exifTagCopyright); copyright != "" {
+		m.Copyright = copyright
+	}
+	return m
+}
+
+// Filter returns the metadata that policy allows in the output.
+func (m Metadata) Filter(policy MetadataPolicy) Metadata {
+	switch policy {
+	case MetadataKeep:
+		kept := m
+		if len(m.EXIF) > 0 {
+			kept.EXIF = exifResetOrientation(m.EXIF)
+		} else {
+			// PNG text chunks have no WebP equivalent, so carry the author
+			// and copyright over as EXIF like MetadataCopyright does
+			kept.EXIF = buildEXIF(m.Artist, m.Copyright)
+		}
+		return kept
+	case MetadataCopyright:
+		return Metadata{
+			EXIF:      buildEXIF(m.Artist, m.Copyright),
+			ICC:       m.ICC,
+			Artist:    m.Artist,
+			Copyright: m.Copyright,
+		}
+	default:
+		return Metadata{}
+	}
+}
+
+// Embed returns WebP data with the metadata blocks added as EXIF, ICCP and
+// XMP chunks of an extended (VP8X) container.
+func (m Metadata) Embed(data []byte) ([]byte, error) {
+	chunks := []struct {
+		format string
+		block  []byte
+	}{
+		{"ICCP", m.ICC},
+		{"EXIF", m.EXIF},
+		{"XMP", m.XMP},
+	}
+
+	var err error
+	for _, chunk := range chunks {
+		if len(chunk.block) == 0 {
+			continue
+		}
+		if data, err = webp.SetMetadata(data, chunk.block, chunk.format); err != nil {
+			return nil, fmt.Errorf("writing %s metadata: %w", chunk.format, err)
+		}
+	}
+	return data, nil
+}
+
+func jpegMetadata(data []byte) Metadata {
+	var m Metadata
+	iccChunks := make(map[int][]byte)
+
+	jpegSegments(data, func(marker byte, payload []byte) bool {
+		switch {
+		case marker == jpegMarkerAPP1 && bytes.HasPrefix(payload, exifHeader) && m.EXIF == nil:
+			m.EXIF = payload[len(exifHeader):]
+		case marker == jpegMarkerAPP1 && bytes.HasPrefix(payload, xmpHeader) && m.XMP == nil:
+			m.XMP = payload[len(xmpHeader):]
+		case marker == jpegMarkerAPP2 && bytes.HasPrefix(payload, iccHeader) && len(payload) > len(iccHeader)+2:
+			// Profiles larger than a segment are split into numbered chunks
+			seq := int(payload[len(iccHeader)])
+			iccChunks[seq] = payload[len(iccHeader)+2:]
+		}
+		return true
+	})
+
+	if len(iccChunks) > 0 {
+		seqs := make([]int, 0, len(iccChunks))
+		for seq := range iccChunks {
+			seqs = append(seqs, seq)
+		}
+		sort.Ints(seqs)
+		for _, seq := range seqs {
+			m.ICC = append(m.ICC, iccChunks[seq]...)
+		}
+	}
+
+	return m
+}
+
+func pngMetadata(data []byte) Metadata {
+	var m Metadata
+
+	for i := len(pngHeader); i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		kind := string(data[i+4 : i+8])
+		if length < 0 || i+12+length > len(data) {
+			break
+		}
+		chunk := data[i+8 : i+8+length]
+		i += 12 + length
+
+		switch kind {
+		case "IEND":
+			return m
+		case "eXIf":
+			m.EXIF = chunk
+		case "iCCP":
+			// Profile name, NUL, compression method, zlib stream
+			if _, rest, ok := bytes.Cut(chunk, []byte{0}); ok && len(rest) > 1 {
+				m.ICC, _ = inflate(rest[1:])
+			}
+		case "tEXt":
+			if keyword, text, ok := bytes.Cut(chunk, []byte{0}); ok {
+				m.setPNGText(string(keyword), string(text))
+			}
+		case "zTXt":
+			if keyword, rest, ok := bytes.Cut(chunk, []byte{0}); ok && len(rest) > 1 {
+				if text, err := inflate(rest[1:]); err == nil {
+					m.setPNGText(string(keyword), string(text))
+				}
+			}
+		case "iTXt":
+			keyword, text, ok := pngInternationalText(chunk)
+			if !ok {
+				continue
+			}
+			if keyword == pngXMPKeyword {
+				m.XMP = []byte(text)
+			} else {
+				m.setPNGText(keyword, text)
+			}
+		}
+	}
+
+	return m
+}
+
+// setPNGText records the PNG text keywords that map onto EXIF fields.
+func (m *Metadata) setPNGText(keyword, text string) {
+	switch keyword {
+	case "Author":
+		m.Artist = text
+	case "Copyright":
+		m.Copyright = text
+	}
+}
+
+// pngInternationalText decodes an iTXt chunk.
+func pngInternationalText(chunk []byte) (string, string, bool) {
+	keyword, rest, ok := bytes.Cut(chunk, []byte{0})
+	if !ok || len(rest) < 2 {
+		return "", "", false
+	}
+	compressed := rest[0] == 1
+
+	// Skip the language tag and the translated keyword
+	_, rest, ok = bytes.Cut(rest[2:], []byte{0})
+	if !ok {
+		return "", "", false
+	}
+	_, text, ok := bytes.Cut(rest, []byte{0})
+	if !ok {
+		return "", "", false
+	}
+
+	if compressed {
+		inflated, err := inflate(text)
+		if err != nil {
+			return "", "", false
+		}
+		text = inflated
+	}
+	return string(keyword), string(text), true
+}
+
+func inflate(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// exifASCII returns the value of an ASCII tag in the first IFD of a
+// TIFF-structured EXIF payload.
+func exifASCII(tiff []byte, tag uint16) string {
+	order, entry := exifFindTag(tiff, tag)
+	if entry < 0 || order.Uint16(tiff[entry+2:entry+4]) != exifTypeASCII {
+		return ""
+	}
+
+	count := int(order.Uint32(tiff[entry+4 : entry+8]))
+	start := entry + 8
+	if count > 4 {
+		start = int(order.Uint32(tiff[entry+8 : entry+12]))
+	}
+	if count < 0 || start < 0 || start+count > len(tiff) {
+		return ""
+	}
+	return strings.TrimRight(string(tiff[start:start+count]), "\x00 ")
+}
+
+// exifResetOrientation returns a copy of tiff whose orientation, if any, is
+// OrientationNormal.
+func exifResetOrientation(tiff []byte) []byte {
+	reset := append([]byte(nil), tiff...)
+	if order, offset := exifOrientationOffset(reset); offset >= 0 {
+		order.PutUint16(reset[offset:offset+2], OrientationNormal)
+	}
+	return reset
+}
+
+// buildEXIF returns a big-endian EXIF payload holding only the artist and
+// copyright tags, or nil when both are empty.
+func buildEXIF(artist, copyright string) []byte {
+	type field struct {
+		tag   uint16
+		value string
+	}
+	var fields []field
+	if artist != "" {
+		fields = append(fields, field{exifTagArtist, artist})
+	}
+	if copyright != "" {
+		fields = append(fields, field{exifTagCopyright, copyright})
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	order := binary.BigEndian
+	ifdSize := 2 + len(fields)*12 + 4
+	header := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	ifd := make([]byte, ifdSize)
+	var values []byte
+
+	order.PutUint16(ifd[0:2], uint16(len(fields)))
+	for i, f := range fields {
+		value := append([]byte(f.value), 0)
+		entry := ifd[2+i*12 : 2+(i+1)*12]
+		order.PutUint16(entry[0:2], f.tag)
+		order.PutUint16(entry[2:4], exifTypeASCII)
+		order.PutUint32(entry[4:8], uint32(len(value)))
+		if len(value) <= 4 {
+			copy(entry[8:12], value)
+			continue
+		}
+		order.PutUint32(entry[8:12], uint32(len(header)+ifdSize+len(values)))
+		values = append(values, value...)
+	}
+
+	return append(append(header, ifd...), values...)
+}
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// pngWithText returns a 1×1 PNG with a tEXt chunk holding keyword and text.
+func pngWithText(t *testing.T, keyword, text string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// Insert the chunk right after IHDR, which follows the 8-byte signature
+	payload := append([]byte(keyword+"\x00"), text...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, "tEXt"...)
+	chunk = append(chunk, payload...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	ihdrEnd := 8 + 8 + 13 + 4
+	out := append([]byte{}, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+func TestFilterKeepsPNGCopyright(t *testing.T) {
+	m := ExtractMetadata(pngWithText(t, "Copyright", "(c) Example"))
+	if m.Copyright != "(c) Example" {
+		t.Fatalf("Copyright = %q, want %q", m.Copyright, "(c) Example")
+	}
+
+	for _, policy := range []MetadataPolicy{MetadataKeep, MetadataCopyright} {
+		kept := m.Filter(policy)
+		if kept.IsEmpty() {
+			t.Errorf("Filter(%s) dropped the copyright", policy)
+			continue
+		}
+		if got := exifASCII(kept.EXIF, exifTagCopyright); got != "(c) Example" {
+			t.Errorf("Filter(%s) EXIF copyright = %q, want %q", policy, got, "(c) Example")
+		}
+	}
+
+	if kept := m.Filter(MetadataStrip); !kept.IsEmpty() {
+		t.Errorf("Filter(strip) kept %+v", kept)
+	}
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// OutputLayout decides where converted files are written.
+type OutputLayout struct {
+	// Dir is the output directory. Empty writes each file next to its
+	// original.
+	Dir string
+
+	// PreserveRelative recreates each file's path relative to SourceRoot
+	// under Dir instead of writing every file directly into Dir.
+	PreserveRelative bool
+	SourceRoot       string
+
+	// Template names each output file relative to its directory and may
+	// create subdirectories, e.g. "{date}/{name}.webp". See TemplateTokens.
+	// Empty uses DefaultTemplate.
+	Template string
+}
+
+// Validate reports whether the layout is usable: PreserveRelative needs a
+// Dir to recreate paths under, and the template must be valid.
+func (l OutputLayout) Validate() error {
+	if l.PreserveRelative && l.Dir == "" {
+		return fmt.Errorf("preserving relative paths needs an output directory")
+	}
+	if l.Template == "" {
+		return nil
+	}
+	return ValidateTemplate(l.Template)
+}
+
+// Path returns the directory the output for inputPath is written to and its
+// name template relative to that directory, to be passed to ConvertFile.
+// Template tokens are left in place; ConvertFile fills them in once the image
+// has been decoded.
+func (l OutputLayout) Path(inputPath string) (dir, template string, err error) {
+	template = l.Template
+	if template == "" {
+		template = DefaultTemplate
+	}
+
+	if l.Dir == "" {
+		return filepath.Dir(inputPath), template, nil
+	}
+
+	if !l.PreserveRelative {
+		return l.Dir, template, nil
+	}
+
+	root, err := filepath.Abs(l.SourceRoot)
+	if err != nil {
+		return "", "", err
+	}
+	abs, err := filepath.Abs(inputPath)
+	if err != nil {
+		return "", "", err
+	}
+	if !isWithin(abs, root) {
+		return "", "", fmt.Errorf("%s is outside the source root %s", inputPath, l.SourceRoot)
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(l.Dir, filepath.Dir(rel)), template, nil
+}
+
+// CommonDir returns the deepest directory containing every path, or "" when
+// the paths share no directory (for example, different Windows drives).
+func CommonDir(paths []string) string {
+	var common string
+	for i, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return ""
+		}
+		dir := filepath.Dir(abs)
+		if i == 0 {
+			common = dir
+			continue
+		}
+		for !isWithin(dir, common) {
+			parent := filepath.Dir(common)
+			if parent == common {
+				return ""
+			}
+			common = parent
+		}
+	}
+	return common
+}
+
+// isWithin reports whether dir is root or lies beneath it.
+func isWithin(dir, root string) bool {
+	rel, err := filepath.Rel(root, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
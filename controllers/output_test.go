@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputLayoutValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout OutputLayout
+		ok     bool
+	}{
+		{"defaults", OutputLayout{}, true},
+		{"output dir", OutputLayout{Dir: "out", Template: "{name}.webp"}, true},
+		{"preserve", OutputLayout{Dir: "out", PreserveRelative: true}, true},
+		{"preserve without dir", OutputLayout{PreserveRelative: true, SourceRoot: "src"}, false},
+		{"bad template", OutputLayout{Dir: "out", Template: "{size}.webp"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.layout.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestOutputLayoutPath(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(root, "src")
+	out := filepath.Join(root, "out")
+
+	tests := []struct {
+		name   string
+		layout OutputLayout
+		input  string
+		// dir is empty when Path should fail
+		dir      string
+		template string
+	}{
+		{"next to original", OutputLayout{}, "src/a/photo.png", "src/a", DefaultTemplate},
+		{"output dir", OutputLayout{Dir: out, Template: "{date}/{name}.webp"}, "src/a/photo.png", "out", "{date}/{name}.webp"},
+		{"preserve", OutputLayout{Dir: out, PreserveRelative: true, SourceRoot: src}, "src/a/b/photo.png", "out/a/b", DefaultTemplate},
+		{"preserve at root", OutputLayout{Dir: out, PreserveRelative: true, SourceRoot: src}, "src/photo.png", "out", DefaultTemplate},
+		{"preserve with template", OutputLayout{Dir: out, PreserveRelative: true, SourceRoot: src, Template: "{width}/{name}.webp"}, "src/a/photo.png", "out/a", "{width}/{name}.webp"},
+		{"outside root", OutputLayout{Dir: out, PreserveRelative: true, SourceRoot: src}, "other/photo.png", "", ""},
+		{"sibling with root prefix", OutputLayout{Dir: out, PreserveRelative: true, SourceRoot: src}, "src2/photo.png", "", ""},
+	}
+	for _, tt := range tests {
+		input := filepath.Join(root, filepath.FromSlash(tt.input))
+		dir, template, err := tt.layout.Path(input)
+		if tt.dir == "" {
+			if err == nil {
+				t.Errorf("%s: Path(%s) = %s, %s; want an error", tt.name, tt.input, dir, template)
+			}
+			continue
+		}
+		want := filepath.Join(root, filepath.FromSlash(tt.dir))
+		if err != nil || dir != want || template != tt.template {
+			t.Errorf("%s: Path(%s) = %s, %s, %v; want %s, %s", tt.name, tt.input, dir, template, err, want, tt.template)
+		}
+	}
+}
+
+func TestCommonDir(t *testing.T) {
+	root := t.TempDir()
+	tests := []struct {
+		paths []string
+		want  string
+	}{
+		{[]string{"a/photo.png"}, "a"},
+		{[]string{"a/one.png", "a/two.png"}, "a"},
+		{[]string{"a/b/one.png", "a/c/two.png"}, "a"},
+		{[]string{"a/b/c/one.png", "a/two.png"}, "a"},
+		{[]string{"ab/one.png", "a/two.png"}, "."},
+		{[]string{"a/one.png", "b/two.png", "a/c/three.png"}, "."},
+	}
+	for _, tt := range tests {
+		var paths []string
+		for _, path := range tt.paths {
+			paths = append(paths, filepath.Join(root, filepath.FromSlash(path)))
+		}
+		want := filepath.Join(root, filepath.FromSlash(tt.want))
+		if got := CommonDir(paths); got != want {
+			t.Errorf("CommonDir(%q) = %s, want %s", tt.paths, got, want)
+		}
+	}
+	if got := CommonDir(nil); got != "" {
+		t.Errorf("CommonDir(nil) = %q, want empty", got)
+	}
+}
@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Netpbm (PBM, PGM and PPM) decoding, in both the plain (ASCII) and raw
+// (binary) variants.
+
+func init() {
+	for _, magic := range []string{"P1", "P2", "P3", "P4", "P5", "P6"} {
+		image.RegisterFormat("pnm", magic, decodePNM, decodePNMConfig)
+	}
+}
+
+// maxPNMPixels guards against headers that would allocate absurd images.
+const maxPNMPixels = 1 << 28
+
+type pnmHeader struct {
+	magic         string
+	width, height int
+	maxval        int
+}
+
+func readPNMHeader(r *bufio.Reader) (pnmHeader, error) {
+	var h pnmHeader
+
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return h, err
+	}
+	h.magic = string(magic)
+	if magic[0] != 'P' || magic[1] < '1' || magic[1] > '6' {
+		return h, errors.New("pnm: invalid format")
+	}
+
+	var err error
+	if h.width, err = readPNMInt(r); err != nil {
+		return h, err
+	}
+	if h.height, err = readPNMInt(r); err != nil {
+		return h, err
+	}
+	if h.width <= 0 || h.height <= 0 || h.width > maxPNMPixels/h.height {
+		return h, fmt.Errorf("pnm: invalid dimensions %dx%d", h.width, h.height)
+	}
+
+	h.maxval = 1
+	if h.magic != "P1" && h.magic != "P4" {
+		if h.maxval, err = readPNMInt(r); err != nil {
+			return h, err
+		}
+		if h.maxval < 1 || h.maxval > 65535 {
+			return h, fmt.Errorf("pnm: invalid maximum value %d", h.maxval)
+		}
+	}
+
+	return h, nil
+}
+
+// readPNMInt reads a decimal number, skipping whitespace and comments before
+// it and consuming the single whitespace character after it.
+func readPNMInt(r *bufio.Reader) (int, error) {
+	c, err := skipPNMSpace(r)
+	if err != nil {
+		return 0, err
+	}
+	if c < '0' || c > '9' {
+		return 0, errors.New("pnm: invalid header")
+	}
+
+	n := 0
+	for c >= '0' && c <= '9' {
+		n = n*10 + int(c-'0')
+		if n > 1<<30 {
+			return 0, errors.New("pnm: number too large")
+		}
+		if c, err = r.ReadByte(); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	if !isPNMSpace(c) {
+		return 0, errors.New("pnm: invalid header")
+	}
+	return n, nil
+}
+
+// skipPNMSpace returns the first byte that is neither whitespace nor part of
+// a comment.
+func skipPNMSpace(r *bufio.Reader) (byte, error) {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c == '#' {
+			if _, err := r.ReadString('\n'); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if !isPNMSpace(c) {
+			return c, nil
+		}
+	}
+}
+
+func isPNMSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func decodePNMConfig(r io.Reader) (image.Config, error) {
+	h, err := readPNMHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	model := color.GrayModel
+	switch {
+	case (h.magic == "P3" || h.magic == "P6") && h.maxval > 255:
+		model = color.RGBA64Model
+	case h.magic == "P3" || h.magic == "P6":
+		model = color.RGBAModel
+	case h.maxval > 255:
+		model = color.Gray16Model
+	}
+	return image.Config{ColorModel: model, Width: h.width, Height: h.height}, nil
+}
+
+func decodePNM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readPNMHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	switch h.magic {
+	case "P1", "P4":
+		return decodePBM(br, h)
+	}
+
+	channels := 1
+	if h.magic == "P3" || h.magic == "P6" {
+		channels = 3
+	}
+	plain := h.magic == "P2" || h.magic == "P3"
+
+	// next returns the next sample scaled to 16 bits
+	next := func() (uint16, error) {
+		var v int
+		if plain {
+			n, err := readPNMInt(br)
+			if err != nil {
+				return 0, err
+			}
+			v = n
+		} else if h.maxval > 255 {
+			var b [2]byte
+			if _, err := io.ReadFull(br, b[:]); err != nil {
+				return 0, err
+			}
+			v = int(b[0])<<8 | int(b[1])
+		} else {
+			b, err := br.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			v = int(b)
+		}
+		if v > h.maxval {
+			v = h.maxval
+		}
+		return uint16(v * 0xFFFF / h.maxval), nil
+	}
+
+	var img image.Image
+	switch {
+	case channels == 1 && h.maxval > 255:
+		img = image.NewGray16(image.Rect(0, 0, h.width, h.height))
+	case channels == 1:
+		img = image.NewGray(image.Rect(0, 0, h.width, h.height))
+	case h.maxval > 255:
+		img = image.NewRGBA64(image.Rect(0, 0, h.width, h.height))
+	default:
+		img = image.NewRGBA(image.Rect(0, 0, h.width, h.height))
+	}
+
+	for y := 0; y < h.height; y++ {
+		for x := 0; x < h.width; x++ {
+			var sample [3]uint16
+			for c := 0; c < channels; c++ {
+				if sample[c], err = next(); err != nil {
+					return nil, fmt.Errorf("pnm: reading pixel data: %w", err)
+				}
+			}
+
+			switch m := img.(type) {
+			case *image.Gray16:
+				m.SetGray16(x, y, color.Gray16{Y: sample[0]})
+			case *image.Gray:
+				m.SetGray(x, y, color.Gray{Y: uint8(sample[0] >> 8)})
+			case *image.RGBA64:
+				m.SetRGBA64(x, y, color.RGBA64{R: sample[0], G: sample[1], B: sample[2], A: 0xFFFF})
+			case *image.RGBA:
+				m.SetRGBA(x, y, color.RGBA{R: uint8(sample[0] >> 8), G: uint8(sample[1] >> 8), B: uint8(sample[2] >> 8), A: 0xFF})
+			}
+		}
+	}
+
+	return img, nil
+}
+
+// decodePBM decodes a bitmap, where 1 is black and 0 is white.
+func decodePBM(r *bufio.Reader, h pnmHeader) (image.Image, error) {
+	img := image.NewGray(image.Rect(0, 0, h.width, h.height))
+
+	if h.magic == "P4" {
+		row := make([]byte, (h.width+7)/8)
+		for y := 0; y < h.height; y++ {
+			if _, err := io.ReadFull(r, row); err != nil {
+				return nil, fmt.Errorf("pnm: reading pixel data: %w", err)
+			}
+			for x := 0; x < h.width; x++ {
+				if row[x/8]&(0x80>>(x%8)) == 0 {
+					img.Pix[y*img.Stride+x] = 0xFF
+				}
+			}
+		}
+		return img, nil
+	}
+
+	for i := 0; i < h.width*h.height; i++ {
+		c, err := skipPNMSpace(r)
+		if err != nil {
+			return nil, fmt.Errorf("pnm: reading pixel data: %w", err)
+		}
+		switch c {
+		case '0':
+			img.Pix[(i/h.width)*img.Stride+i%h.width] = 0xFF
+		case '1':
+		default:
+			return nil, errors.New("pnm: invalid bitmap data")
+		}
+	}
+	return img, nil
+}
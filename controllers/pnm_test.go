@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDecodePNM(t *testing.T) {
+	black, white := color.Gray{Y: 0}, color.Gray{Y: 0xFF}
+
+	tests := []struct {
+		name  string
+		data  string
+		model color.Model
+		// want lists the pixels row by row
+		want []color.Color
+		w, h int
+	}{
+		{
+			name:  "P1 plain bitmap",
+			data:  "P1\n2 2\n1 0\n0 1\n",
+			model: color.GrayModel,
+			w:     2, h: 2,
+			want: []color.Color{black, white, white, black},
+		},
+		{
+			name:  "P1 without separators",
+			data:  "P1 3 1 101",
+			model: color.GrayModel,
+			w:     3, h: 1,
+			want: []color.Color{black, white, black},
+		},
+		{
+			name:  "P2 plain graymap scaled from maxval",
+			data:  "P2\n3 1\n15\n0 15 5\n",
+			model: color.GrayModel,
+			w:     3, h: 1,
+			want: []color.Color{black, white, color.Gray{Y: 0x55}},
+		},
+		{
+			name:  "P3 plain pixmap",
+			data:  "P3\n2 1\n255\n10 20 30  40 50 60\n",
+			model: color.RGBAModel,
+			w:     2, h: 1,
+			want: []color.Color{
+				color.RGBA{R: 10, G: 20, B: 30, A: 0xFF},
+				color.RGBA{R: 40, G: 50, B: 60, A: 0xFF},
+			},
+		},
+		{
+			name:  "P4 raw bitmap with row padding",
+			data:  "P4\n3 2\n\xA0\x40",
+			model: color.GrayModel,
+			w:     3, h: 2,
+			want: []color.Color{black, white, black, white, black, white},
+		},
+		{
+			name:  "P5 raw graymap",
+			data:  "P5\n2 1\n255\n\x00\xC8",
+			model: color.GrayModel,
+			w:     2, h: 1,
+			want: []color.Color{black, color.Gray{Y: 0xC8}},
+		},
+		{
+			name:  "P6 raw pixmap",
+			data:  "P6\n1 1\n255\n\x01\x02\x03",
+			model: color.RGBAModel,
+			w:     1, h: 1,
+			want: []color.Color{color.RGBA{R: 1, G: 2, B: 3, A: 0xFF}},
+		},
+		{
+			name:  "P5 16-bit samples",
+			data:  "P5\n2 1\n1000\n\x03\xE8\x01\xF4",
+			model: color.Gray16Model,
+			w:     2, h: 1,
+			want: []color.Color{color.Gray16{Y: 0xFFFF}, color.Gray16{Y: 500 * 0xFFFF / 1000}},
+		},
+		{
+			name:  "P6 16-bit samples",
+			data:  "P6\n1 1\n65535\n\x12\x34\x56\x78\x9A\xBC",
+			model: color.RGBA64Model,
+			w:     1, h: 1,
+			want: []color.Color{color.RGBA64{R: 0x1234, G: 0x5678, B: 0x9ABC, A: 0xFFFF}},
+		},
+		{
+			name:  "P3 16-bit plain samples",
+			data:  "P3 1 1 4095 4095 0 2048",
+			model: color.RGBA64Model,
+			w:     1, h: 1,
+			want: []color.Color{color.RGBA64{R: 0xFFFF, G: 0, B: 2048 * 0xFFFF / 4095, A: 0xFFFF}},
+		},
+		{
+			name:  "comments in the header and data",
+			data:  "P2 # width follows\n# a whole comment line\n2 # height\n1\n# maxval\n255\n# pixels\n7 # first\n9\n",
+			model: color.GrayModel,
+			w:     2, h: 1,
+			want: []color.Color{color.Gray{Y: 7}, color.Gray{Y: 9}},
+		},
+		{
+			name:  "samples above maxval are clamped",
+			data:  "P2 1 1 100 250",
+			model: color.GrayModel,
+			w:     1, h: 1,
+			want: []color.Color{white},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := decodePNMConfig(bytes.NewReader([]byte(tt.data)))
+			if err != nil {
+				t.Fatalf("decodePNMConfig: %v", err)
+			}
+			if config.Width != tt.w || config.Height != tt.h || config.ColorModel != tt.model {
+				t.Errorf("config = %dx%d %T, want %dx%d", config.Width, config.Height, config.ColorModel, tt.w, tt.h)
+			}
+
+			img, err := decodePNM(bytes.NewReader([]byte(tt.data)))
+			if err != nil {
+				t.Fatalf("decodePNM: %v", err)
+			}
+			if img.ColorModel() != tt.model {
+				t.Errorf("decoded as %T", img)
+			}
+			if got := img.Bounds(); got != image.Rect(0, 0, tt.w, tt.h) {
+				t.Fatalf("bounds = %v, want %dx%d", got, tt.w, tt.h)
+			}
+			for i, want := range tt.want {
+				x, y := i%tt.w, i/tt.w
+				if !sameColor(img.At(x, y), want) {
+					t.Errorf("pixel (%d, %d) = %v, want %v", x, y, img.At(x, y), want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodePNMErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"unknown magic", "P7\n1 1\n255\n\x00"},
+		{"zero width", "P5\n0 1\n255\n"},
+		{"missing height", "P5\n1"},
+		{"zero maxval", "P5\n1 1\n0\n\x00"},
+		{"maxval too large", "P5\n1 1\n70000\n\x00"},
+		{"letters in header", "P2\nwide 1\n255\n0"},
+		{"unterminated comment", "P2\n# no newline"},
+		{"truncated P1", "P1\n2 2\n1 0 1"},
+		{"invalid P1 digit", "P1\n1 1\n2"},
+		{"truncated P2", "P2\n2 1\n255\n7"},
+		{"truncated P4", "P4\n8 2\n\xFF"},
+		{"truncated P5", "P5\n2 2\n255\n\x00\x00\x00"},
+		{"truncated P6", "P6\n2 1\n255\n\x01\x02\x03\x04"},
+		{"truncated 16-bit sample", "P5\n1 1\n65535\n\x12"},
+		{"dimensions too large", "P5\n65536 65536\n255\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if img, err := decodePNM(bytes.NewReader([]byte(tt.data))); err == nil {
+				t.Errorf("decodePNM succeeded with %v", img.Bounds())
+			}
+		})
+	}
+}
+
+// sameColor reports whether a and b are the same colour once converted to
+// 16-bit RGBA.
+func sameColor(a, b color.Color) bool {
+	ar, ag, ab, aa := a.RGBA()
+	br, bg, bb, ba := b.RGBA()
+	return ar == br && ag == bg && ab == bb && aa == ba
+}
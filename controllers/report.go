@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Report entry statuses
+const (
+	StatusConverted = "converted"
+	StatusCopied    = "copied"
+	StatusSkipped   = "skipped"
+	StatusUpToDate  = "up-to-date"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// ReportEntry is one file of an exported batch report.
+type ReportEntry struct {
+	Source      string  `json:"source"`
+	Output      string  `json:"output,omitempty"`
+	Status      string  `json:"status"`
+	InputFormat string  `json:"input_format,omitempty"`
+	Width       int     `json:"width,omitempty"`
+	Height      int     `json:"height,omitempty"`
+	BytesBefore int64   `json:"bytes_before"`
+	BytesAfter  int64   `json:"bytes_after"`
+	Quality     float32 `json:"quality,omitempty"`
+	Lossless    bool    `json:"lossless,omitempty"`
+	SSIM        float64 `json:"ssim,omitempty"`
+	DurationMS  int64   `json:"duration_ms"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// NewReportEntry describes the conversion of inputPath, which produced
+// report or failed with err.
+func NewReportEntry(inputPath string, report Report, err error) ReportEntry {
+	entry := ReportEntry{
+		Source:      inputPath,
+		InputFormat: report.InputFormat,
+		Width:       report.Width,
+		Height:      report.Height,
+		BytesBefore: report.InputBytes,
+		BytesAfter:  report.OutputBytes,
+		Quality:     report.Quality,
+		Lossless:    report.Lossless,
+		SSIM:        report.SSIM,
+		DurationMS:  report.Duration.Milliseconds(),
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		entry.Status = StatusCancelled
+	case err != nil:
+		entry.Status = StatusFailed
+		entry.Error = err.Error()
+	case report.UpToDate:
+		entry.Status = StatusUpToDate
+	case report.Skipped:
+		entry.Status = StatusSkipped
+	case report.Copied:
+		entry.Status = StatusCopied
+	default:
+		entry.Status = StatusConverted
+	}
+
+	if err == nil && (!report.Skipped || report.UpToDate) {
+		entry.Output = report.OutputPath
+	}
+	if entry.Lossless {
+		entry.Quality = 0
+	}
+	return entry
+}
+
+// Report export formats
+const (
+	ReportJSON = "json"
+	ReportCSV  = "csv"
+)
+
+// ReportFormatForPath returns the export format implied by the extension of
+// path.
+func ReportFormatForPath(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ReportJSON, nil
+	case ".csv":
+		return ReportCSV, nil
+	}
+	return "", fmt.Errorf("report file must end in .json or .csv")
+}
+
+// WriteReport writes entries to w in format.
+func WriteReport(w io.Writer, entries []ReportEntry, format string) error {
+	switch format {
+	case ReportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Files []ReportEntry `json:"files"`
+		}{entries})
+	case ReportCSV:
+		return writeReportCSV(w, entries)
+	}
+	return fmt.Errorf("unknown report format %q", format)
+}
+
+func writeReportCSV(w io.Writer, entries []ReportEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"source", "output", "status", "input_format", "width", "height",
+		"bytes_before", "bytes_after", "quality", "ssim", "duration_ms", "error",
+	})
+
+	for _, e := range entries {
+		quality := ""
+		if e.Lossless {
+			quality = "lossless"
+		} else if e.Quality > 0 {
+			quality = strconv.FormatFloat(float64(e.Quality), 'g', -1, 32)
+		}
+		ssim := ""
+		if e.SSIM > 0 {
+			ssim = strconv.FormatFloat(e.SSIM, 'f', 4, 64)
+		}
+		dimension := func(n int) string {
+			if n == 0 {
+				return ""
+			}
+			return strconv.Itoa(n)
+		}
+		cw.Write([]string{
+			e.Source, e.Output, e.Status, e.InputFormat,
+			dimension(e.Width), dimension(e.Height),
+			strconv.FormatInt(e.BytesBefore, 10), strconv.FormatInt(e.BytesAfter, 10),
+			quality, ssim, strconv.FormatInt(e.DurationMS, 10), e.Error,
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// SaveReport writes entries to path, choosing the format from its
+// extension.
+func SaveReport(path string, entries []ReportEntry) error {
+	format, err := ReportFormatForPath(path)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, entries, format); err != nil {
+		return err
+	}
+	_, _, err = writeOutput(path, buf.Bytes(), CollisionOverwrite)
+	return err
+}
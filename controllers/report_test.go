@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+)
+
+func TestReportSSIM(t *testing.T) {
+	entries := []ReportEntry{
+		NewReportEntry("a.png", Report{OutputPath: "a.webp", Quality: 62, SSIM: 0.95123}, nil),
+		NewReportEntry("b.png", Report{OutputPath: "b.webp", Quality: 80}, nil),
+	}
+
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, entries, ReportCSV); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	col := -1
+	for i, name := range rows[0] {
+		if name == "ssim" {
+			col = i
+		}
+	}
+	if col < 0 {
+		t.Fatalf("CSV header %q has no ssim column", rows[0])
+	}
+	if got := rows[1][col]; got != "0.9512" {
+		t.Errorf("ssim of a.png = %q, want 0.9512", got)
+	}
+	if got := rows[2][col]; got != "" {
+		t.Errorf("ssim of b.png = %q, want empty", got)
+	}
+
+	buf.Reset()
+	if err := WriteReport(&buf, entries, ReportJSON); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), `"ssim"`); n != 1 {
+		t.Errorf("JSON report has %d ssim fields, want 1:\n%s", n, buf.String())
+	}
+}
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// ResizeMode selects how an image is fitted to the requested dimensions.
+type ResizeMode string
+
+const (
+	// ResizeFit scales the image down until it fits within the maximum
+	// dimensions, preserving its aspect ratio. It never upscales.
+	ResizeFit ResizeMode = "fit"
+	// ResizeFill scales the image down until it covers the maximum dimensions
+	// and crops the overflow around the centre, preserving its aspect ratio.
+	ResizeFill ResizeMode = "fill"
+	// ResizeExact stretches the image to exactly the requested dimensions.
+	ResizeExact ResizeMode = "exact"
+)
+
+// ResizeModes lists every ResizeMode in display order.
+var ResizeModes = []ResizeMode{ResizeFit, ResizeFill, ResizeExact}
+
+// Resampler selects the interpolation kernel used when resizing.
+type Resampler string
+
+const (
+	ResampleNearest    Resampler = "nearest"
+	ResampleBilinear   Resampler = "bilinear"
+	ResampleCatmullRom Resampler = "catmull-rom"
+	ResampleLanczos    Resampler = "lanczos"
+)
+
+// Resamplers lists every Resampler in display order.
+var Resamplers = []Resampler{ResampleNearest, ResampleBilinear, ResampleCatmullRom, ResampleLanczos}
+
+// ResizeOptions controls resizing between decoding and encoding. The zero
+// value leaves images untouched.
+type ResizeOptions struct {
+	// MaxWidth and MaxHeight bound the output size in pixels. Zero leaves
+	// that dimension unconstrained (or unchanged for ResizeExact).
+	MaxWidth  int
+	MaxHeight int
+
+	// Mode defaults to ResizeFit.
+	Mode ResizeMode
+
+	// Resampler defaults to ResampleCatmullRom.
+	Resampler Resampler
+}
+
+// Enabled reports whether the options resize anything.
+func (o ResizeOptions) Enabled() bool {
+	return o.MaxWidth > 0 || o.MaxHeight > 0
+}
+
+// Validate reports whether the options can be used for resizing.
+func (o ResizeOptions) Validate() error {
+	if o.MaxWidth < 0 || o.MaxHeight < 0 {
+		return fmt.Errorf("resize dimensions must not be negative")
+	}
+
+	switch o.Mode {
+	case "", ResizeFit, ResizeExact:
+	case ResizeFill:
+		if o.Enabled() && (o.MaxWidth == 0 || o.MaxHeight == 0) {
+			return fmt.Errorf("fill mode needs both a width and a height")
+		}
+	default:
+		return fmt.Errorf("unknown resize mode %q", o.Mode)
+	}
+
+	switch o.Resampler {
+	case "", ResampleNearest, ResampleBilinear, ResampleCatmullRom, ResampleLanczos:
+	default:
+		return fmt.Errorf("unknown resampler %q", o.Resampler)
+	}
+
+	return nil
+}
+
+// Resize returns img scaled according to opts, or img itself when no
+// resizing is needed.
+func Resize(img image.Image, opts ResizeOptions) image.Image {
+	src, w, h := opts.plan(img.Bounds())
+	return scale(img, src, w, h, opts.Resampler)
+}
+
+// Size returns the dimensions Resize produces for a w×h image.
+func (o ResizeOptions) Size(w, h int) (int, int) {
+	_, w, h = o.plan(image.Rect(0, 0, w, h))
+	return w, h
+}
+
+// plan returns the part of bounds to sample and the output size.
+func (o ResizeOptions) plan(bounds image.Rectangle) (image.Rectangle, int, int) {
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if !o.Enabled() || srcW == 0 || srcH == 0 {
+		return bounds, srcW, srcH
+	}
+
+	switch o.Mode {
+	case ResizeExact:
+		w, h := o.MaxWidth, o.MaxHeight
+		if w == 0 {
+			w = srcW
+		}
+		if h == 0 {
+			h = srcH
+		}
+		return bounds, w, h
+
+	case ResizeFill:
+		factor := math.Min(1, math.Max(
+			float64(o.MaxWidth)/float64(srcW),
+			float64(o.MaxHeight)/float64(srcH),
+		))
+		w := min(o.MaxWidth, scaled(srcW, factor))
+		h := min(o.MaxHeight, scaled(srcH, factor))
+
+		// Crop the source to the target aspect ratio around its centre
+		cropW := min(srcW, scaled(w, 1/factor))
+		cropH := min(srcH, scaled(h, 1/factor))
+		x := bounds.Min.X + (srcW-cropW)/2
+		y := bounds.Min.Y + (srcH-cropH)/2
+		return image.Rect(x, y, x+cropW, y+cropH), w, h
+
+	default:
+		factor := 1.0
+		if o.MaxWidth > 0 {
+			factor = math.Min(factor, float64(o.MaxWidth)/float64(srcW))
+		}
+		if o.MaxHeight > 0 {
+			factor = math.Min(factor, float64(o.MaxHeight)/float64(srcH))
+		}
+		if factor >= 1 {
+			return bounds, srcW, srcH
+		}
+		return bounds, scaled(srcW, factor), scaled(srcH, factor)
+	}
+}
+
+// scaled returns n multiplied by factor, rounded and at least 1.
+func scaled(n int, factor float64) int {
+	return max(1, int(math.Round(float64(n)*factor)))
+}
+
+func scale(img image.Image, src image.Rectangle, w, h int, resampler Resampler) image.Image {
+	if src == img.Bounds() && w == src.Dx() && h == src.Dy() {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	resampler.interpolator().Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
+
+func (r Resampler) interpolator() draw.Interpolator {
+	switch r {
+	case ResampleNearest:
+		return draw.NearestNeighbor
+	case ResampleBilinear:
+		return draw.BiLinear
+	case ResampleLanczos:
+		return lanczos3
+	default:
+		return draw.CatmullRom
+	}
+}
+
+// lanczos3 is the Lanczos kernel with a = 3, which x/image/draw does not
+// provide.
+var lanczos3 = &draw.Kernel{
+	Support: 3,
+	At: func(t float64) float64 {
+		if t == 0 {
+			return 1
+		}
+		x := math.Pi * t
+		return 3 * math.Sin(x) * math.Sin(x/3) / (x * x)
+	},
+}
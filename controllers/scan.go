@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ScanOptions controls which files ScanDirectory collects.
+type ScanOptions struct {
+	// Recursive descends into subdirectories.
+	Recursive bool
+
+	// Include keeps only files matching at least one pattern; empty keeps
+	// every supported image. Exclude drops matching files and directories.
+	// Patterns without a slash match the base name, patterns with one match
+	// the slash-separated path relative to the scanned directory.
+	Include []string
+	Exclude []string
+
+	// FollowSymlinks descends into symlinked directories and includes
+	// symlinked files. Symlinks are skipped otherwise.
+	FollowSymlinks bool
+}
+
+// SplitPatterns splits a comma-separated list of glob patterns, dropping
+// blanks.
+func SplitPatterns(s string) []string {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// Validate reports whether every pattern is well formed.
+func (o ScanOptions) Validate() error {
+	for _, p := range append(append([]string(nil), o.Include...), o.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", p)
+		}
+	}
+	return nil
+}
+
+// ScanDirectory returns the supported images under root that match opts, in
+// lexical order. Only an unreadable root is an error: subdirectories that
+// cannot be read are skipped and reported in warnings.
+func ScanDirectory(root string, opts ScanOptions) (files []string, warnings []error, err error) {
+	if err := opts.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	s := &scanner{root: root, opts: opts, visited: make(map[string]bool)}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		s.visited[real] = true
+	}
+	if err := s.scan(root); err != nil {
+		return nil, nil, err
+	}
+	return s.files, s.warnings, nil
+}
+
+type scanner struct {
+	root     string
+	opts     ScanOptions
+	files    []string
+	warnings []error
+
+	// visited holds the resolved directories already scanned, so symlink
+	// cycles terminate.
+	visited map[string]bool
+}
+
+// readDir lists a directory for the scanner.
+var readDir = os.ReadDir
+
+func (s *scanner) scan(dir string) error {
+	entries, err := readDir(dir)
+	if err != nil {
+		return fmt.Errorf("reading directory %s: %w", dir, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		full := filepath.Join(dir, entry.Name())
+		rel, err := filepath.Rel(s.root, full)
+		if err != nil {
+			rel = entry.Name()
+		}
+		rel = filepath.ToSlash(rel)
+
+		if matchAny(s.opts.Exclude, rel) {
+			continue
+		}
+
+		isDir := entry.IsDir()
+		if entry.Type()&os.ModeSymlink != 0 {
+			if !s.opts.FollowSymlinks {
+				continue
+			}
+			info, err := os.Stat(full)
+			if err != nil {
+				// Dangling link
+				continue
+			}
+			isDir = info.IsDir()
+		}
+
+		if isDir {
+			if !s.opts.Recursive {
+				continue
+			}
+			real, err := filepath.EvalSymlinks(full)
+			if err != nil || s.visited[real] {
+				continue
+			}
+			s.visited[real] = true
+			if err := s.scan(full); err != nil {
+				// One unreadable folder doesn't spoil the rest
+				s.warnings = append(s.warnings, err)
+			}
+			continue
+		}
+
+		if !entry.Type().IsRegular() && entry.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		if !IsSupportedImage(entry.Name()) {
+			continue
+		}
+		if len(s.opts.Include) > 0 && !matchAny(s.opts.Include, rel) {
+			continue
+		}
+		s.files = append(s.files, full)
+	}
+
+	return nil
+}
+
+// matchAny reports whether rel, or its base name for patterns without a
+// slash, matches any of the patterns. Matching ignores case.
+func matchAny(patterns []string, rel string) bool {
+	rel = strings.ToLower(rel)
+	for _, p := range patterns {
+		name := rel
+		if !strings.Contains(p, "/") {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(strings.ToLower(p), name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestScanDirectoryUnreadable(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.png", "locked/b.png", "open/c.png", "open/locked/d.png"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	denied := errors.New("permission denied")
+	unreadable := map[string]bool{}
+	saved := readDir
+	readDir = func(dir string) ([]os.DirEntry, error) {
+		if unreadable[dir] {
+			return nil, denied
+		}
+		return saved(dir)
+	}
+	defer func() { readDir = saved }()
+
+	unreadable[filepath.Join(root, "locked")] = true
+	unreadable[filepath.Join(root, "open", "locked")] = true
+	files, warnings, err := ScanDirectory(root, ScanOptions{Recursive: true})
+	if err != nil {
+		t.Fatalf("unreadable subfolders: %v", err)
+	}
+	want := []string{filepath.Join(root, "a.png"), filepath.Join(root, "open", "c.png")}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+	if len(warnings) != 2 {
+		t.Fatalf("warnings = %v, want one per unreadable folder", warnings)
+	}
+	for _, warning := range warnings {
+		if !errors.Is(warning, denied) {
+			t.Errorf("warning %v doesn't wrap the read error", warning)
+		}
+	}
+
+	unreadable[root] = true
+	if _, _, err := ScanDirectory(root, ScanOptions{Recursive: true}); !errors.Is(err, denied) {
+		t.Errorf("unreadable root: err = %v, want %v", err, denied)
+	}
+	if _, _, err := ScanDirectory(filepath.Join(root, "missing"), ScanOptions{}); err == nil {
+		t.Error("missing root: no error")
+	}
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+
+	"github.com/chai2010/webp"
+)
+
+const (
+	// SSIM is computed on luma over ssimWindow-pixel squares placed every
+	// ssimStride pixels.
+	ssimWindow = 8
+	ssimStride = 4
+
+	// Stabilising constants for 8-bit samples, (0.01*255)² and (0.03*255)²
+	ssimC1 = 6.5025
+	ssimC2 = 58.5225
+)
+
+// encodePerceptual returns the encoding at the lowest quality whose decoded
+// result has an SSIM of at least opts.MinSSIM against the source. When no
+// quality gets there, quality 100 is used and its score reported.
+func (c *Converter) encodePerceptual(ctx context.Context, source *source, opts Options) (*encoded, error) {
+	reference := newLuma(source.img)
+
+	// Similarity grows with quality
+	best, rejected, err := c.searchQuality(ctx, source, opts, func(e *encoded) (bool, error) {
+		decoded, err := webp.Decode(bytes.NewReader(e.data))
+		if err != nil {
+			return false, fmt.Errorf("measuring quality: %w", err)
+		}
+		e.ssim = ssim(reference, newLuma(decoded))
+		return e.ssim >= opts.MinSSIM, nil
+	}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if best == nil {
+		// The search ended on quality 100
+		return rejected, nil
+	}
+	return best, nil
+}
+
+// luma is the 8-bit luma plane of an image.
+type luma struct {
+	width, height int
+	pix           []float64
+}
+
+// newLuma extracts the BT.601 luma of img.
+func newLuma(img image.Image) *luma {
+	bounds := img.Bounds()
+	l := &luma{
+		width:  bounds.Dx(),
+		height: bounds.Dy(),
+		pix:    make([]float64, bounds.Dx()*bounds.Dy()),
+	}
+
+	switch img := img.(type) {
+	case *image.YCbCr:
+		for y := 0; y < l.height; y++ {
+			for x := 0; x < l.width; x++ {
+				l.pix[y*l.width+x] = float64(img.Y[img.YOffset(bounds.Min.X+x, bounds.Min.Y+y)])
+			}
+		}
+	default:
+		for y := 0; y < l.height; y++ {
+			for x := 0; x < l.width; x++ {
+				r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+				l.pix[y*l.width+x] = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+			}
+		}
+	}
+	return l
+}
+
+// ssim returns the mean structural similarity of two luma planes of the same
+// size, from 0 (unrelated) to 1 (identical). Planes smaller than a window are
+// compared as a whole.
+func ssim(a, b *luma) float64 {
+	if a.width != b.width || a.height != b.height || a.width == 0 || a.height == 0 {
+		return 0
+	}
+
+	winW, winH := min(ssimWindow, a.width), min(ssimWindow, a.height)
+	var total float64
+	windows := 0
+	for y := 0; y+winH <= a.height; y += ssimStride {
+		for x := 0; x+winW <= a.width; x += ssimStride {
+			total += ssimWindowAt(a, b, x, y, winW, winH)
+			windows++
+		}
+	}
+	return total / float64(windows)
+}
+
+// ssimWindowAt computes SSIM over the w×h window at x, y.
+func ssimWindowAt(a, b *luma, x, y, w, h int) float64 {
+	var sumA, sumB, sumAA, sumBB, sumAB float64
+	for j := y; j < y+h; j++ {
+		row := j * a.width
+		for i := x; i < x+w; i++ {
+			pa, pb := a.pix[row+i], b.pix[row+i]
+			sumA += pa
+			sumB += pb
+			sumAA += pa * pa
+			sumBB += pb * pb
+			sumAB += pa * pb
+		}
+	}
+
+	n := float64(w * h)
+	meanA, meanB := sumA/n, sumB/n
+	varA := sumAA/n - meanA*meanA
+	varB := sumBB/n - meanB*meanB
+	cov := sumAB/n - meanA*meanB
+
+	return ((2*meanA*meanB + ssimC1) * (2*cov + ssimC2)) /
+		((meanA*meanA + meanB*meanB + ssimC1) * (varA + varB + ssimC2))
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrTargetUnreachable is returned when even quality 1 exceeds the target
+// size.
+var ErrTargetUnreachable = errors.New("target size cannot be reached")
+
+// encodeTarget returns the encoding at the highest quality whose size does
+// not exceed opts.TargetSize.
+func (c *Converter) encodeTarget(ctx context.Context, source *source, opts Options) (*encoded, error) {
+	// Output size grows with quality
+	best, rejected, err := c.searchQuality(ctx, source, opts, func(e *encoded) (bool, error) {
+		return int64(len(e.data)) <= opts.TargetSize, nil
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if best == nil {
+		// The search ended on quality 1
+		return nil, fmt.Errorf("%w: %s at quality 1, over the %s target",
+			ErrTargetUnreachable, FormatSize(int64(len(rejected.data))), FormatSize(opts.TargetSize))
+	}
+	return best, nil
+}
+
+// searchQuality bisects over the whole-number qualities for the one where
+// accept changes its verdict, which must only ever go one way as quality
+// rises. With preferHigh it returns the highest accepted encoding, otherwise
+// the lowest. rejected is the last encoding refused: when none is accepted,
+// the one at the far end of the range, quality 1 or 100.
+func (c *Converter) searchQuality(ctx context.Context, source *source, opts Options, accept func(*encoded) (bool, error), preferHigh bool) (best, rejected *encoded, err error) {
+	lo, hi := 1, 100
+	for lo <= hi {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		quality := (lo + hi) / 2
+		data, err := c.encodeQuality(source, opts, float32(quality))
+		if err != nil {
+			return nil, nil, err
+		}
+		candidate := &encoded{data: data, quality: float32(quality)}
+		ok, err := accept(candidate)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if ok {
+			best = candidate
+		} else {
+			rejected = candidate
+		}
+		// Look past an accepted quality in the preferred direction, and
+		// away from a rejected one
+		if ok == preferHigh {
+			lo = quality + 1
+		} else {
+			hi = quality - 1
+		}
+	}
+	return best, rejected, nil
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTemplate names each output after its source file.
+const DefaultTemplate = "{name}.webp"
+
+// TemplateTokens lists the tokens a filename template may use.
+//
+//	{name}       source file name without its extension
+//	{ext}        source extension without the dot
+//	{width}      output width in pixels
+//	{height}     output height in pixels
+//	{quality}    encoding quality, or "lossless"
+//	{hash}       first 8 hex digits of the SHA-256 of the source file
+//	{date}       source modification date, as 2006-01-02
+//	{time}       source modification time, as 150405
+//	{timestamp}  source modification time in Unix seconds
+var TemplateTokens = []string{
+	"name", "ext", "width", "height", "quality", "hash", "date", "time", "timestamp",
+}
+
+// CollisionPolicy decides what happens when the output file already exists.
+type CollisionPolicy string
+
+const (
+	// CollisionOverwrite replaces the existing file. It is the default.
+	CollisionOverwrite CollisionPolicy = "overwrite"
+	// CollisionSkip leaves the existing file alone and skips the conversion.
+	CollisionSkip CollisionPolicy = "skip"
+	// CollisionSuffix appends -1, -2, ... to the name until it is unused.
+	CollisionSuffix CollisionPolicy = "suffix"
+	// CollisionError fails the conversion.
+	CollisionError CollisionPolicy = "error"
+)
+
+// CollisionPolicies lists every CollisionPolicy in display order.
+var CollisionPolicies = []CollisionPolicy{CollisionOverwrite, CollisionSkip, CollisionSuffix, CollisionError}
+
+// ValidateTemplate reports whether template is a usable relative file name
+// template.
+func ValidateTemplate(template string) error {
+	if strings.TrimSpace(template) == "" {
+		return fmt.Errorf("filename template is empty")
+	}
+	if filepath.IsAbs(template) {
+		return fmt.Errorf("filename template must be relative")
+	}
+	for _, part := range strings.Split(filepath.ToSlash(template), "/") {
+		if part == ".." {
+			return fmt.Errorf("filename template must not contain ..")
+		}
+	}
+
+	rest := template
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			return nil
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return fmt.Errorf("unclosed { in filename template")
+		}
+		token := rest[start+1 : start+end]
+		if !isTemplateToken(token) {
+			return fmt.Errorf("unknown filename token {%s}", token)
+		}
+		rest = rest[start+end+1:]
+	}
+}
+
+func isTemplateToken(token string) bool {
+	for _, t := range TemplateTokens {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+// templateVars holds the values substituted into a filename template.
+type templateVars struct {
+	inputPath string
+	width     int
+	height    int
+	opts      Options
+	data      []byte
+	modTime   time.Time
+}
+
+// expandTemplate replaces the tokens in pattern. The hash is only computed
+// when the pattern asks for it.
+func expandTemplate(pattern string, vars templateVars) string {
+	if !strings.Contains(pattern, "{") {
+		return pattern
+	}
+
+	base := filepath.Base(vars.inputPath)
+	ext := filepath.Ext(base)
+	pairs := []string{
+		"{name}", strings.TrimSuffix(base, ext),
+		"{ext}", strings.TrimPrefix(ext, "."),
+		"{width}", strconv.Itoa(vars.width),
+		"{height}", strconv.Itoa(vars.height),
+		"{date}", vars.modTime.Format("2006-01-02"),
+		"{time}", vars.modTime.Format("150405"),
+		"{timestamp}", strconv.FormatInt(vars.modTime.Unix(), 10),
+	}
+	// A searched quality is filled in after encoding
+	if !vars.opts.searchesQuality() {
+		pairs = append(pairs, "{quality}", formatQuality(vars.opts.Quality, vars.opts))
+	}
+	if strings.Contains(pattern, "{hash}") {
+		pairs = append(pairs, "{hash}", contentHash(vars.data)[:8])
+	}
+	return strings.NewReplacer(pairs...).Replace(pattern)
+}
+
+// formatQuality renders quality for the {quality} token.
+func formatQuality(quality float32, opts Options) string {
+	if opts.Lossless {
+		return "lossless"
+	}
+	return strconv.Itoa(int(quality))
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		ok       bool
+	}{
+		{"{name}.webp", true},
+		{"{date}/{name}-{width}x{height}-q{quality}.webp", true},
+		{"{hash}_{time}_{timestamp}.{ext}.webp", true},
+		{"plain.webp", true},
+		{"", false},
+		{"   ", false},
+		{"/abs/{name}.webp", false},
+		{"../{name}.webp", false},
+		{"sub/../../{name}.webp", false},
+		{"{name.webp", false},
+		{"{size}.webp", false},
+		{"{}.webp", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateTemplate(tt.template); (err == nil) != tt.ok {
+			t.Errorf("ValidateTemplate(%q) = %v, want ok = %v", tt.template, err, tt.ok)
+		}
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	modTime := time.Date(2024, 3, 9, 14, 5, 7, 0, time.UTC)
+	vars := templateVars{
+		inputPath: filepath.Join("photos", "holiday.shot.JPG"),
+		width:     640,
+		height:    480,
+		opts:      Options{Quality: 82},
+		data:      []byte("source"),
+		modTime:   modTime,
+	}
+	hash := contentHash(vars.data)[:8]
+
+	tests := []struct {
+		name    string
+		pattern string
+		opts    *Options
+		want    string
+	}{
+		{"no tokens", "fixed.webp", nil, "fixed.webp"},
+		{"name and ext", "{name}.{ext}.webp", nil, "holiday.shot.JPG.webp"},
+		{"size and quality", "{name}-{width}x{height}-q{quality}.webp", nil, "holiday.shot-640x480-q82.webp"},
+		{"dates", "{date}/{time}-{timestamp}.webp", nil, "2024-03-09/140507-1709993107.webp"},
+		{"hash", "{hash}.webp", nil, hash + ".webp"},
+		{"repeated", "{name}/{name}.webp", nil, "holiday.shot/holiday.shot.webp"},
+		{"lossless", "q{quality}.webp", &Options{Lossless: true}, "qlossless.webp"},
+		{"searched quality", "{name}-q{quality}.webp", &Options{Quality: 80, TargetSize: 1000}, "holiday.shot-q{quality}.webp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := vars
+			if tt.opts != nil {
+				v.opts = *tt.opts
+			}
+			if got := expandTemplate(tt.pattern, v); got != tt.want {
+				t.Errorf("expandTemplate(%q) = %q, want %q", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
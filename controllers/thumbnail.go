@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"image"
+	"os"
+)
+
+// Thumbnail decodes the image at path and scales it down to fit within a
+// size×size square. It also returns the dimensions of the full image, after
+// EXIF orientation.
+func Thumbnail(path string, size int) (thumb image.Image, width, height int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer file.Close()
+
+	img, err := DecodeImage(file, path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	bounds := (*img).Bounds()
+	thumb = Resize(*img, ResizeOptions{
+		MaxWidth:  size,
+		MaxHeight: size,
+		Mode:      ResizeFit,
+		Resampler: ResampleBilinear,
+	})
+	return thumb, bounds.Dx(), bounds.Dy(), nil
+}
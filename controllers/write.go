@@ -0,0 +1,306 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrOutputExists is returned by ConvertFile under CollisionError.
+var ErrOutputExists = errors.New("output file already exists")
+
+// ErrOutputIsSource is returned by ConvertFile when the output path names the
+// source file, as converting a .webp next to itself with the default template
+// does. Writing it would replace the original with a re-encode of itself.
+var ErrOutputIsSource = errors.New("output would overwrite the source file")
+
+// Outputs are encoded into a hidden temp file next to their final path and
+// renamed into place once complete, so an interrupted conversion never leaves
+// a truncated .webp behind. The prefix and suffix let CleanupTempFiles find
+// temp files orphaned by a crash, and the journal kept by recordTempDir lets
+// CleanupRecordedTempFiles find them on the next start.
+const (
+	tempPrefix = ".imgc-"
+	tempSuffix = ".tmp"
+)
+
+// staleTempAge is how old a temp file must be before cleanup removes it. A
+// temp file only lives while its output is written and synced, so one this
+// old was orphaned rather than belonging to a conversion still running.
+const staleTempAge = time.Hour
+
+// maxSuffix bounds the search for a free name under CollisionSuffix.
+const maxSuffix = 10000
+
+// createTemp creates the temp file that will become path.
+func createTemp(path string) (*os.File, error) {
+	recordTempDir(filepath.Dir(path))
+	file, err := os.CreateTemp(filepath.Dir(path), tempPrefix+filepath.Base(path)+".*"+tempSuffix)
+	if err != nil {
+		return nil, err
+	}
+	// CreateTemp only grants the owner access; outputs get regular
+	// permissions
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// writeOutput writes data to path through a synced temp file, creating
+// missing parent directories, and resolves collisions according to policy.
+// It returns the path used and whether the write was skipped.
+func writeOutput(path string, data []byte, policy CollisionPolicy) (string, bool, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return path, false, fmt.Errorf("creating output directory: %w", err)
+	}
+
+	tempFile, err := createTemp(path)
+	if err != nil {
+		return path, false, fmt.Errorf("creating output: %w", err)
+	}
+	tempPath := tempFile.Name()
+
+	_, err = tempFile.Write(data)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return path, false, fmt.Errorf("writing output: %w", err)
+	}
+
+	used, skipped, err := commitOutput(tempPath, path, policy)
+	if err != nil {
+		os.Remove(tempPath)
+		return used, false, fmt.Errorf("writing output: %w", err)
+	}
+	return used, skipped, nil
+}
+
+// outputExists reports whether something already occupies path.
+func outputExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// commitOutput moves the finished temp file to path according to policy and
+// returns the path used. skipped is set when policy is CollisionSkip and path
+// exists, in which case the temp file is removed.
+func commitOutput(tempPath, path string, policy CollisionPolicy) (used string, skipped bool, err error) {
+	if policy == "" || policy == CollisionOverwrite {
+		return path, false, os.Rename(tempPath, path)
+	}
+
+	candidate := path
+	for i := 1; ; i++ {
+		err := renameNoReplace(tempPath, candidate)
+		if err == nil {
+			return candidate, false, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return candidate, false, err
+		}
+
+		switch policy {
+		case CollisionSkip:
+			os.Remove(tempPath)
+			return path, true, nil
+		case CollisionError:
+			os.Remove(tempPath)
+			return path, false, ErrOutputExists
+		}
+		if i > maxSuffix {
+			os.Remove(tempPath)
+			return path, false, fmt.Errorf("no free name for %s", path)
+		}
+		ext := filepath.Ext(path)
+		candidate = strings.TrimSuffix(path, ext) + "-" + strconv.Itoa(i) + ext
+	}
+}
+
+// renameNoReplace renames oldPath to newPath unless newPath exists. A hard
+// link makes the check and the rename one step, so concurrent workers aiming
+// at the same name cannot both claim it. File systems without hard links fall
+// back to a separate check.
+func renameNoReplace(oldPath, newPath string) error {
+	err := os.Link(oldPath, newPath)
+	if err == nil {
+		return os.Remove(oldPath)
+	}
+	if outputExists(newPath) {
+		return os.ErrExist
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// CleanupTempFiles removes the temp files that interrupted conversions left
+// in dir, descending into subdirectories when recursive is set, and returns
+// how many were removed. Only files older than staleTempAge are touched, so
+// conversions still running in another process keep theirs. A missing dir is
+// not an error.
+func CleanupTempFiles(dir string, recursive bool) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			if path == dir {
+				return err
+			}
+			// Unreadable subdirectories are left alone
+			return nil
+		}
+		if entry.IsDir() {
+			if path != dir && !recursive {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if isStaleTemp(entry) && os.Remove(path) == nil {
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// isStaleTemp reports whether entry is a temp file old enough that no running
+// conversion still owns it.
+func isStaleTemp(entry fs.DirEntry) bool {
+	name := entry.Name()
+	if !entry.Type().IsRegular() || !strings.HasPrefix(name, tempPrefix) || !strings.HasSuffix(name, tempSuffix) {
+		return false
+	}
+	info, err := entry.Info()
+	return err == nil && time.Since(info.ModTime()) > staleTempAge
+}
+
+// outputDirs returns the distinct directories the jobs write to. Jobs whose
+// directory depends on template tokens are left out, and templated reports
+// whether there were any: where those land is only known once converted, so
+// the journal is what finds their temp files.
+func outputDirs(jobs []Job) (dirs []string, templated bool) {
+	seen := make(map[string]bool)
+	for _, job := range jobs {
+		dir := filepath.Dir(job.OutputPath)
+		if strings.ContainsRune(dir, '{') {
+			templated = true
+			continue
+		}
+		dir = filepath.Join(job.OutputDir, dir)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, templated
+}
+
+// tempJournal returns the file listing the directories temp files have been
+// created in, one per line, so CleanupRecordedTempFiles can find the ones a
+// crash orphaned once the program starts again.
+var tempJournal = func() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "image-compressor", "temp-dirs"), nil
+}
+
+// recordedTempDirs holds the directories this process has already added to
+// the journal.
+var recordedTempDirs sync.Map
+
+// recordTempDir adds dir to the journal. It is best effort: a directory
+// missing from the journal only goes uncleaned until a batch writes there
+// again.
+func recordTempDir(dir string) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	if _, loaded := recordedTempDirs.LoadOrStore(dir, true); loaded {
+		return
+	}
+	journal, err := tempJournal()
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(journal), 0o755); err != nil {
+		return
+	}
+	file, err := os.OpenFile(journal, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return
+	}
+	file.WriteString(dir + "\n")
+	file.Close()
+}
+
+// CleanupRecordedTempFiles removes stale temp files from every directory
+// listed in the journal and returns how many were removed. Directories left
+// without temp files are dropped from the journal. Programs call it on
+// startup, before knowing where the next batch will write.
+func CleanupRecordedTempFiles() (int, error) {
+	journal, err := tempJournal()
+	if err != nil {
+		return 0, err
+	}
+	data, err := os.ReadFile(journal)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	removed := 0
+	seen := make(map[string]bool)
+	var keep []string
+	for _, dir := range strings.Split(string(data), "\n") {
+		if dir == "" || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		n, _ := CleanupTempFiles(dir, false)
+		removed += n
+		if hasTempFiles(dir) {
+			// Still in use by another process, or not removable yet
+			keep = append(keep, dir)
+		}
+	}
+
+	var rest []byte
+	for _, dir := range keep {
+		rest = append(rest, dir+"\n"...)
+	}
+	return removed, os.WriteFile(journal, rest, 0o644)
+}
+
+// hasTempFiles reports whether dir holds any temp files, stale or not.
+func hasTempFiles(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, tempPrefix) && strings.HasSuffix(name, tempSuffix) {
+			return true
+		}
+	}
+	return false
+}
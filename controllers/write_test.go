@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCleanupTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * staleTempAge)
+	files := map[string]bool{
+		// path: whether cleanup removes it
+		".imgc-a.webp.1.tmp":          true,
+		".imgc-b.webp.2.tmp":          false, // recent, still being written
+		"photo.webp":                  false,
+		"sub/.imgc-c.webp.3.tmp":      true,
+		"sub/deep/.imgc-d.webp.4.tmp": true,
+	}
+	for name, stale := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if stale || name == "photo.webp" {
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	removed, err := CleanupTempFiles(dir, false)
+	if err != nil || removed != 1 {
+		t.Fatalf("CleanupTempFiles(recursive=false) = %d, %v; want 1, nil", removed, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub/.imgc-c.webp.3.tmp")); err != nil {
+		t.Errorf("non-recursive cleanup touched a subdirectory: %v", err)
+	}
+
+	removed, err = CleanupTempFiles(dir, true)
+	if err != nil || removed != 2 {
+		t.Fatalf("CleanupTempFiles(recursive=true) = %d, %v; want 2, nil", removed, err)
+	}
+	for name, stale := range files {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists == stale {
+			t.Errorf("%s: exists = %v after cleanup", name, exists)
+		}
+	}
+
+	if removed, err := CleanupTempFiles(filepath.Join(dir, "missing"), true); err != nil || removed != 0 {
+		t.Errorf("missing dir: got %d, %v", removed, err)
+	}
+}
+
+func TestOutputDirs(t *testing.T) {
+	tests := []struct {
+		// jobs are "dir|template" pairs
+		jobs      []string
+		dirs      []string
+		templated bool
+	}{
+		{[]string{"out|a.webp", "out|b.webp", "src|{name}.webp"}, []string{"out", "src"}, false},
+		{[]string{"out|a.webp", "out|{width}x{height}/c.webp"}, []string{"out"}, true},
+		{[]string{"out|{date}/a.webp", "src|{date}/sub/b.webp"}, nil, true},
+		{[]string{"out|sub/{name}-small.webp"}, []string{"out/sub"}, false},
+		// A real folder named like a token
+		{[]string{"{date}|{name}.webp"}, []string{"{date}"}, false},
+	}
+	for _, tt := range tests {
+		var jobs []Job
+		var want []string
+		for _, job := range tt.jobs {
+			dir, template, _ := strings.Cut(job, "|")
+			jobs = append(jobs, Job{OutputDir: filepath.FromSlash(dir), OutputPath: filepath.FromSlash(template)})
+		}
+		for _, dir := range tt.dirs {
+			want = append(want, filepath.FromSlash(dir))
+		}
+		dirs, templated := outputDirs(jobs)
+		if !reflect.DeepEqual(dirs, want) || templated != tt.templated {
+			t.Errorf("outputDirs(%q) = %q, %v; want %q, %v", tt.jobs, dirs, templated, want, tt.templated)
+		}
+	}
+}
+
+func TestCleanupRecordedTempFiles(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "temp-dirs")
+	saved := tempJournal
+	tempJournal = func() (string, error) { return journal, nil }
+	defer func() { tempJournal = saved }()
+
+	stale, busy := t.TempDir(), t.TempDir()
+	old := time.Now().Add(-2 * staleTempAge)
+	for _, dir := range []string{stale, busy} {
+		file, err := createTemp(filepath.Join(dir, "a.webp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		if dir == stale {
+			os.Chtimes(file.Name(), old, old)
+		}
+	}
+
+	removed, err := CleanupRecordedTempFiles()
+	if err != nil || removed != 1 {
+		t.Fatalf("CleanupRecordedTempFiles = %d, %v; want 1, nil", removed, err)
+	}
+	data, err := os.ReadFile(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), busy+"\n"; got != want {
+		t.Errorf("journal = %q, want %q", got, want)
+	}
+}
+
+// TestConvertBatchTemplatedCleanup checks that a batch whose output folders
+// come from template tokens cleans the folders in the journal.
+func TestConvertBatchTemplatedCleanup(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "temp-dirs")
+	saved := tempJournal
+	tempJournal = func() (string, error) { return journal, nil }
+	defer func() { tempJournal = saved }()
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "photo.png")
+	writeTestImage(t, input, 16, 16)
+	if err := os.Mkdir(filepath.Join(dir, "16x16"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := createTemp(filepath.Join(dir, "16x16", "photo.webp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	old := time.Now().Add(-2 * staleTempAge)
+	if err := os.Chtimes(file.Name(), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := []Job{{InputPath: input, OutputDir: dir, OutputPath: filepath.Join("{width}x{height}", "photo.webp")}}
+	for result := range NewConverter().ConvertBatch(context.Background(), jobs, Options{Quality: 80}, BatchOptions{}) {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+	}
+	if _, err := os.Stat(file.Name()); !os.IsNotExist(err) {
+		t.Errorf("stale temp file in templated folder survived the batch: %v", err)
+	}
+}
+
+func TestCommitOutput(t *testing.T) {
+	tests := []struct {
+		policy CollisionPolicy
+		// existing are the files already in the directory
+		existing []string
+		used     string
+		skipped  bool
+		err      error
+		// kept is the content left at used
+		kept string
+	}{
+		{CollisionOverwrite, nil, "a.webp", false, nil, "new"},
+		{CollisionOverwrite, []string{"a.webp"}, "a.webp", false, nil, "new"},
+		{CollisionSkip, nil, "a.webp", false, nil, "new"},
+		{CollisionSkip, []string{"a.webp"}, "a.webp", true, nil, "old"},
+		{CollisionError, []string{"a.webp"}, "a.webp", false, ErrOutputExists, "old"},
+		{CollisionSuffix, nil, "a.webp", false, nil, "new"},
+		{CollisionSuffix, []string{"a.webp"}, "a-1.webp", false, nil, "new"},
+		{CollisionSuffix, []string{"a.webp", "a-1.webp", "a-2.webp"}, "a-3.webp", false, nil, "new"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		for _, name := range tt.existing {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("old"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		temp := filepath.Join(dir, ".imgc-a.webp.1.tmp")
+		if err := os.WriteFile(temp, []byte("new"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		used, skipped, err := commitOutput(temp, filepath.Join(dir, "a.webp"), tt.policy)
+		if used != filepath.Join(dir, tt.used) || skipped != tt.skipped || !errors.Is(err, tt.err) {
+			t.Errorf("%s with %q: got %s, %v, %v; want %s, %v, %v", tt.policy, tt.existing, filepath.Base(used), skipped, err, tt.used, tt.skipped, tt.err)
+			continue
+		}
+		if data, _ := os.ReadFile(used); string(data) != tt.kept {
+			t.Errorf("%s with %q: %s holds %q, want %q", tt.policy, tt.existing, tt.used, data, tt.kept)
+		}
+		if outputExists(temp) {
+			t.Errorf("%s with %q: temp file left behind", tt.policy, tt.existing)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"gioui.org/app"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/unit"
+	"gioui.org/widget/material"
+	"github.com/Sakaino2/image-compressor/controllers"
+)
+
+const (
+	// estimateDelay is how long the settings must stay unchanged before
+	// the size estimate is recomputed.
+	estimateDelay = 300 * time.Millisecond
+
+	// estimateSampleSize is how many files of the list are encoded for the
+	// estimate when none is open in the comparison viewer.
+	estimateSampleSize = 3
+)
+
+// qualityValue returns the quality selected on the slider, from 1 to 100.
+func (a *App) qualityValue() int {
+	return 1 + int(math.Round(float64(a.quality.Value)*99))
+}
+
+// setQuality moves the quality slider to q.
+func (a *App) setQuality(q int) {
+	a.quality.Value = float32(q-1) / 99
+}
+
+// sizeEstimate is the estimated output size of the current settings. It
+// belongs to the UI goroutine; encoding happens in the background and posts
+// estimateEvent.
+type sizeEstimate struct {
+	// key identifies the settings and files of the estimate shown or being
+	// computed, and pending those waiting out estimateDelay until due
+	key     string
+	pending string
+	due     time.Time
+
+	cancel context.CancelFunc
+	text   string
+}
+
+// reset drops the estimate and stops any encoding for it.
+func (e *sizeEstimate) reset() {
+	if e.cancel != nil {
+		e.cancel()
+		e.cancel = nil
+	}
+	e.key, e.pending, e.text = "", "", ""
+}
+
+// estimateSample returns the files the estimate encodes: the file open in the
+// comparison viewer, or up to estimateSampleSize files spread across the list.
+func (a *App) estimateSample() []string {
+	if a.compare.item != nil {
+		return []string{a.compare.item.path}
+	}
+
+	n := len(a.fileItems)
+	k := min(n, estimateSampleSize)
+	paths := make([]string, k)
+	for i := range k {
+		paths[i] = a.fileItems[i*n/k].path
+	}
+	return paths
+}
+
+// updateEstimate starts re-encoding the sample once the settings have been
+// left alone for estimateDelay.
+func (a *App) updateEstimate(gtx layout.Context, w *app.Window) {
+	e := &a.estimate
+
+	// Leave the last estimate alone while a batch keeps the CPUs busy
+	if a.processing {
+		return
+	}
+	opts, err := a.encodeOptions()
+	paths := a.estimateSample()
+	if err != nil || len(paths) == 0 {
+		e.reset()
+		return
+	}
+
+	key := fmt.Sprintf("%s %q", opts.Fingerprint(), paths)
+	switch {
+	case key == e.key:
+		return
+	case key != e.pending:
+		e.pending = key
+		e.due = gtx.Now.Add(estimateDelay)
+		gtx.Execute(op.InvalidateCmd{At: e.due})
+		return
+	case gtx.Now.Before(e.due):
+		gtx.Execute(op.InvalidateCmd{At: e.due})
+		return
+	}
+
+	if e.cancel != nil {
+		e.cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e.key, e.pending, e.cancel = key, "", cancel
+	e.text = "Estimating output size..."
+
+	sampledFrom := 0
+	if a.compare.item == nil && len(paths) < len(a.fileItems) {
+		sampledFrom = len(a.fileItems)
+	}
+	go a.runEstimate(w, ctx, key, paths, opts, sampledFrom)
+}
+
+// runEstimate encodes paths in memory with opts, as a batch would, and posts
+// the estimated size. sampledFrom is the size of the list paths were sampled
+// from, or zero when they are not a sample.
+func (a *App) runEstimate(w *app.Window, ctx context.Context, key string, paths []string, opts controllers.Options, sampledFrom int) {
+	var before, after int64
+	for _, path := range paths {
+		report, err := a.converter.Estimate(ctx, path, opts)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			a.post(w, estimateEvent{key: key, text: fmt.Sprintf("Cannot estimate size: %v", err)})
+			return
+		}
+		before += report.InputBytes
+		after += report.OutputBytes
+	}
+
+	text := "Estimated size: " + sizeChange(before, after)
+	if sampledFrom > 0 {
+		text += fmt.Sprintf(" (%d of %d files sampled)", len(paths), sampledFrom)
+	}
+	a.post(w, estimateEvent{key: key, text: text})
+}
+
+// estimateEvent delivers a finished size estimate.
+type estimateEvent struct {
+	key  string
+	text string
+}
+
+func (e estimateEvent) apply(a *App) {
+	if a.estimate.key != e.key {
+		// The settings changed since
+		return
+	}
+	if a.estimate.cancel != nil {
+		a.estimate.cancel()
+		a.estimate.cancel = nil
+	}
+	a.estimate.text = e.text
+}
+
+// layoutQuality draws the quality slider with the estimated output size.
+func (a *App) layoutQuality(gtx layout.Context) layout.Dimensions {
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			// Quality has no effect in lossless mode or when a target size
+			// or SSIM picks it
+			if a.mode.Value == modeLossless || a.searchesQuality() {
+				gtx = gtx.Disabled()
+			}
+			return layout.Flex{
+				Axis:      layout.Horizontal,
+				Alignment: layout.Middle,
+			}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					gtx.Constraints.Min.X = gtx.Dp(unit.Dp(110))
+					return material.Body1(a.theme, fmt.Sprintf("Quality: %d", a.qualityValue())).Layout(gtx)
+				}),
+				layout.Flexed(1, material.Slider(a.theme, &a.quality).Layout),
+			)
+		}),
+		layout.Rigid(material.Caption(a.theme, a.estimate.text).Layout),
+	)
+}
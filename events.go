@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"gioui.org/app"
+	"github.com/Sakaino2/image-compressor/controllers"
+)
+
+// appEvent is a change to App state produced by background work. Only the UI
+// goroutine mutates App, so dialogs and conversions post events that are
+// applied at the start of the next frame.
+type appEvent interface {
+	apply(a *App)
+}
+
+// post queues ev for the UI goroutine and wakes the window to apply it.
+func (a *App) post(w *app.Window, ev appEvent) {
+	a.eventsMu.Lock()
+	a.events = append(a.events, ev)
+	a.eventsMu.Unlock()
+	w.Invalidate()
+}
+
+// applyEvents applies the queued events in the order they were posted. It
+// must be called from the UI goroutine.
+func (a *App) applyEvents() {
+	a.eventsMu.Lock()
+	events := a.events
+	a.events = nil
+	a.eventsMu.Unlock()
+
+	for _, ev := range events {
+		ev.apply(a)
+	}
+}
+
+// statusEvent replaces the status text.
+type statusEvent string
+
+func (e statusEvent) apply(a *App) {
+	a.statusText = string(e)
+}
+
+// fileAddedEvent adds a file picked in the file dialog.
+type fileAddedEvent struct {
+	item *FileItem
+}
+
+func (e fileAddedEvent) apply(a *App) {
+	// Check for duplicates
+	if a.hasFile(e.item.path) {
+		a.statusText = "File already in list"
+		return
+	}
+
+	a.fileItems = append(a.fileItems, e.item)
+
+	name := filepath.Base(e.item.path)
+	a.statusText = fmt.Sprintf("Added: %s (Total: %d files). Click Add File to add more.", name, len(a.fileItems))
+	if e.item.warning != "" {
+		a.statusText = fmt.Sprintf("Added: %s (Total: %d files). Warning: %s.", name, len(a.fileItems), e.item.warning)
+	}
+}
+
+// folderAddedEvent adds the files found by scanning folder.
+type folderAddedEvent struct {
+	folder string
+	items  []*FileItem
+
+	// unreadable holds the errors of subfolders that were skipped
+	unreadable []error
+}
+
+func (e folderAddedEvent) apply(a *App) {
+	added, duplicates, warnings := 0, 0, 0
+	for _, item := range e.items {
+		// Check for duplicates
+		if a.hasFile(item.path) {
+			duplicates++
+			continue
+		}
+
+		if item.warning != "" {
+			warnings++
+		}
+		a.fileItems = append(a.fileItems, item)
+		added++
+	}
+
+	a.statusText = fmt.Sprintf("Added %d file(s) from %s (Total: %d files).", added, filepath.Base(e.folder), len(a.fileItems))
+	if duplicates > 0 {
+		a.statusText += fmt.Sprintf(" %d already in list.", duplicates)
+	}
+	if warnings > 0 {
+		a.statusText += fmt.Sprintf(" %d with a misleading extension.", warnings)
+	}
+	switch len(e.unreadable) {
+	case 0:
+	case 1:
+		a.statusText += fmt.Sprintf(" Skipped a folder: %v.", e.unreadable[0])
+	default:
+		a.statusText += fmt.Sprintf(" Skipped %d unreadable folders.", len(e.unreadable))
+	}
+}
+
+// outputDirEvent sets the output directory chosen in the directory dialog.
+type outputDirEvent string
+
+func (e outputDirEvent) apply(a *App) {
+	a.outputDir.SetText(string(e))
+	a.statusText = fmt.Sprintf("Output directory: %s", string(e))
+}
+
+// sourceRootEvent sets the source root chosen in the directory dialog and
+// turns on mirrored output.
+type sourceRootEvent string
+
+func (e sourceRootEvent) apply(a *App) {
+	a.sourceRoot.SetText(string(e))
+	a.preservePaths.Value = true
+	a.statusText = fmt.Sprintf("Source root: %s", string(e))
+}
+
+// jobStartedEvent notes that a worker began encoding a file.
+type jobStartedEvent string
+
+func (e jobStartedEvent) apply(a *App) {
+	if a.progress != nil {
+		a.progress.started(string(e))
+	}
+}
+
+// resultEvent records a finished file of the running batch.
+type resultEvent struct {
+	item   *FileItem
+	result controllers.Result
+}
+
+func (e resultEvent) apply(a *App) {
+	if a.progress != nil {
+		a.progress.finished(e.item, e.result)
+	}
+	e.item.setResult(e.result)
+}
+
+// batchDoneEvent ends the running batch.
+type batchDoneEvent struct {
+	status string
+}
+
+func (e batchDoneEvent) apply(a *App) {
+	a.processing = false
+	a.cancel = nil
+	a.progress = nil
+	a.statusText = e.status
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"image/color"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -15,34 +20,133 @@ import (
 	"gioui.org/widget"
 	"gioui.org/widget/material"
 	"github.com/Sakaino2/image-compressor/controllers"
-	"github.com/chai2010/webp"
 	"github.com/sqweek/dialog"
 )
 
+// Values of App.mode
+const (
+	modeLossy    = "lossy"
+	modeLossless = "lossless"
+)
+
+var resizeModeLabels = map[controllers.ResizeMode]string{
+	controllers.ResizeFit:   "Fit",
+	controllers.ResizeFill:  "Fill",
+	controllers.ResizeExact: "Exact",
+}
+
+// warningColor highlights problems that do not stop a conversion.
+var warningColor = color.NRGBA{R: 0xB2, G: 0x6A, B: 0x00, A: 0xFF}
+
+var metadataLabels = map[controllers.MetadataPolicy]string{
+	controllers.MetadataStrip:     "Strip all",
+	controllers.MetadataKeep:      "Keep all",
+	controllers.MetadataCopyright: "ICC + copyright/author only",
+}
+
+var collisionLabels = map[controllers.CollisionPolicy]string{
+	controllers.CollisionOverwrite: "Overwrite",
+	controllers.CollisionSkip:      "Skip",
+	controllers.CollisionSuffix:    "Add suffix",
+	controllers.CollisionError:     "Fail",
+}
+
+var incrementalLabels = map[controllers.IncrementalMode]string{
+	controllers.IncrementalOff:    "Off",
+	controllers.IncrementalNewer:  "Output newer than source",
+	controllers.IncrementalStrict: "Same content and settings",
+}
+
+var largerLabels = map[controllers.LargerPolicy]string{
+	controllers.LargerWrite: "Write anyway",
+	controllers.LargerSkip:  "Skip",
+	controllers.LargerCopy:  "Copy original",
+}
+
+var resamplerLabels = map[controllers.Resampler]string{
+	controllers.ResampleNearest:    "Nearest",
+	controllers.ResampleBilinear:   "Bilinear",
+	controllers.ResampleCatmullRom: "Catmull-Rom",
+	controllers.ResampleLanczos:    "Lanczos",
+}
+
 type FileItem struct {
 	path      string
+	warning   string
+	size      int64
 	removeBtn widget.Clickable
+	selectBtn widget.Clickable
+
+	// Dimensions of the source, known once its thumbnail is decoded
+	width, height int
+
+	// Outcome of the last conversion
+	status itemStatus
+	report controllers.Report
+	err    error
 }
 
 type App struct {
-	theme        *material.Theme
-	list         widget.List
-	outputDir    widget.Editor
-	quality      widget.Editor
-	convertBtn   widget.Clickable
-	browseBtn    widget.Clickable
-	browseDirBtn widget.Clickable
-	clearBtn     widget.Clickable
-	statusText   string
-	fileItems    []*FileItem
-	processing   bool
+	theme           *material.Theme
+	converter       *controllers.Converter
+	list            widget.List
+	settingsList    widget.List
+	outputDir       widget.Editor
+	preservePaths   widget.Bool
+	sourceRoot      widget.Editor
+	browseRootBtn   widget.Clickable
+	nameTemplate    widget.Editor
+	collision       widget.Enum
+	incremental     widget.Enum
+	larger          widget.Enum
+	quality         widget.Float
+	targetSize      widget.Editor
+	minSSIM         widget.Editor
+	mode            widget.Enum
+	exact           widget.Bool
+	maxWidth        widget.Editor
+	maxHeight       widget.Editor
+	resizeMode      widget.Enum
+	resampler       widget.Enum
+	metadata        widget.Enum
+	concurrency     widget.Editor
+	convertBtn      widget.Clickable
+	cancelBtn       widget.Clickable
+	browseBtn       widget.Clickable
+	addFolderBtn    widget.Clickable
+	browseDirBtn    widget.Clickable
+	clearBtn        widget.Clickable
+	retryBtn        widget.Clickable
+	saveReportBtn   widget.Clickable
+	headerBtns      [numColumns]widget.Clickable
+	sortColumn      column
+	sortDesc        bool
+	includePatterns widget.Editor
+	excludePatterns widget.Editor
+	followSymlinks  widget.Bool
+	statusText      string
+	fileItems       []*FileItem
+	processing      bool
+	cancel          context.CancelFunc
+	progress        *batchProgress
+	thumbs          *thumbnailCache
+	compare         compareView
+	estimate        sizeEstimate
+
+	// Events posted by background work, applied on the UI goroutine
+	eventsMu sync.Mutex
+	events   []appEvent
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		os.Exit(runConvertCommand(os.Args[2:]))
+	}
+
 	go func() {
 		w := new(app.Window)
 		w.Option(app.Title("WebP Image Compressor"))
-		w.Option(app.Size(unit.Dp(700), unit.Dp(600)))
+		w.Option(app.Size(unit.Dp(800), unit.Dp(760)))
 
 		if err := run(w); err != nil {
 			log.Fatal(err)
@@ -55,15 +159,42 @@ func main() {
 func run(w *app.Window) error {
 	a := &App{
 		theme:      material.NewTheme(),
+		converter:  controllers.NewConverter(),
 		statusText: "Ready to convert images. Select files to add.",
 		fileItems:  []*FileItem{},
+		sortColumn: columnNone,
+		thumbs:     newThumbnailCache(),
 	}
 
+	// Decode thumbnails off the frame loop
+	a.thumbs.start(w, a)
+
+	// Remove temp files a killed earlier run left behind
+	go controllers.CleanupRecordedTempFiles()
+
 	// Configure list
 	a.list.Axis = layout.Vertical
+	a.settingsList.Axis = layout.Vertical
+
+	// Set default quality and encoding mode
+	a.setQuality(80)
+	a.mode.Value = modeLossy
+
+	// Resizing is off until a maximum dimension is entered
+	a.resizeMode.Value = string(controllers.ResizeFit)
+	a.resampler.Value = string(controllers.ResampleCatmullRom)
+
+	// Name outputs after their sources, replacing existing files
+	a.nameTemplate.SetText(controllers.DefaultTemplate)
+	a.collision.Value = string(controllers.CollisionOverwrite)
+	a.incremental.Value = string(controllers.IncrementalOff)
+	a.larger.Value = string(controllers.LargerWrite)
+
+	// Strip metadata unless asked to keep it
+	a.metadata.Value = string(controllers.MetadataStrip)
 
-	// Set default quality
-	a.quality.SetText("80")
+	// Default to one worker per CPU
+	a.concurrency.SetText(strconv.Itoa(controllers.DefaultConcurrency()))
 
 	var ops op.Ops
 
@@ -74,9 +205,45 @@ func run(w *app.Window) error {
 		case app.FrameEvent:
 			gtx := app.NewContext(&ops, e)
 
+			// Apply what background work finished since the last frame
+			a.applyEvents()
+
 			// Handle convert button click
 			if a.convertBtn.Clicked(gtx) && !a.processing {
-				go a.convertImages(w)
+				a.convertImages(w, a.fileItems)
+			}
+
+			// Handle retry failed button click
+			if a.retryBtn.Clicked(gtx) && !a.processing {
+				var failed []*FileItem
+				for _, item := range a.fileItems {
+					if item.status == statusFailed {
+						failed = append(failed, item)
+					}
+				}
+				if len(failed) > 0 {
+					a.convertImages(w, failed)
+				}
+			}
+
+			// Handle save report button click
+			if a.saveReportBtn.Clicked(gtx) && !a.processing {
+				go a.saveReport(w, a.reportEntries())
+			}
+
+			// Handle column header clicks
+			a.handleTableClicks(gtx)
+
+			// Handle file selection and the comparison viewer
+			a.handleCompareClicks(gtx, w)
+
+			// Re-estimate the output size once the settings settle
+			a.updateEstimate(gtx, w)
+
+			// Handle cancel button click
+			if a.cancelBtn.Clicked(gtx) && a.processing && a.cancel != nil {
+				a.cancel()
+				a.statusText = "Cancelling..."
 			}
 
 			// Handle browse files button click
@@ -84,25 +251,38 @@ func run(w *app.Window) error {
 				go a.browseFiles(w)
 			}
 
+			// Handle add folder button click
+			if a.addFolderBtn.Clicked(gtx) {
+				go a.browseFolder(w, controllers.ScanOptions{
+					Recursive:      true,
+					Include:        controllers.SplitPatterns(a.includePatterns.Text()),
+					Exclude:        controllers.SplitPatterns(a.excludePatterns.Text()),
+					FollowSymlinks: a.followSymlinks.Value,
+				})
+			}
+
 			// Handle browse directory button click
 			if a.browseDirBtn.Clicked(gtx) {
 				go a.browseDirectory(w)
 			}
 
-			// Handle clear button click
-			if a.clearBtn.Clicked(gtx) {
+			// Handle browse source root button click
+			if a.browseRootBtn.Clicked(gtx) {
+				go a.browseSourceRoot(w)
+			}
+
+			// Handle clear button click; the list is locked while converting
+			if a.clearBtn.Clicked(gtx) && !a.processing {
 				a.fileItems = []*FileItem{}
 				a.statusText = "Files cleared. Select new files to convert."
-				w.Invalidate()
 			}
 
 			// Handle individual remove buttons
-			for i := len(a.fileItems) - 1; i >= 0; i-- {
+			for i := len(a.fileItems) - 1; i >= 0 && !a.processing; i-- {
 				if a.fileItems[i].removeBtn.Clicked(gtx) {
 					// Remove this item
-					a.fileItems = append(a.fileItems[:i], a.fileItems[i+1:]...)
+					a.fileItems = slices.Delete(a.fileItems, i, i+1)
 					a.statusText = fmt.Sprintf("File removed. %d file(s) remaining.", len(a.fileItems))
-					w.Invalidate()
 					break
 				}
 			}
@@ -121,120 +301,414 @@ func (a *App) Layout(gtx layout.Context) layout.Dimensions {
 		Right:  unit.Dp(20),
 	}
 
+	// The comparison viewer takes the whole window while a file is selected
+	if a.compare.item != nil {
+		return inset.Layout(gtx, a.layoutCompare)
+	}
+
+	// The files stay at the top and the buttons acting on them at the bottom;
+	// the settings between them scroll when the window is too short for all
+	// of them
 	return inset.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-		return layout.Flex{
-			Axis:    layout.Vertical,
-			Spacing: layout.SpaceBetween,
-		}.Layout(gtx,
-			// Title
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Inset{Bottom: unit.Dp(20)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-					title := material.H5(a.theme, "WebP Image Compressor - Batch Converter")
-					return title.Layout(gtx)
-				})
-			}),
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(a.layoutFiles),
+			layout.Flexed(1, a.layoutSettings),
+			layout.Rigid(a.layoutActions),
+		)
+	})
+}
 
-			// Files label
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Inset{Bottom: unit.Dp(5)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-					label := material.Body1(a.theme, fmt.Sprintf("Selected files (%d):", len(a.fileItems)))
-					return label.Layout(gtx)
-				})
-			}),
+// layoutFiles draws the title, the file table and the buttons that edit the
+// list.
+func (a *App) layoutFiles(gtx layout.Context) layout.Dimensions {
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		// Title
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(20)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				title := material.H5(a.theme, "WebP Image Compressor - Batch Converter")
+				return title.Layout(gtx)
+			})
+		}),
 
-			// Files list area
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Inset{Bottom: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-					border := widget.Border{
-						Color:        a.theme.Fg,
-						CornerRadius: unit.Dp(4),
-						Width:        unit.Dp(1),
-					}
-					return border.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-						return layout.Inset{
-							Top:    unit.Dp(8),
-							Bottom: unit.Dp(8),
-							Left:   unit.Dp(8),
-							Right:  unit.Dp(8),
-						}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-							// Set fixed height for the list
-							gtx.Constraints.Min.Y = gtx.Dp(unit.Dp(150))
-							gtx.Constraints.Max.Y = gtx.Dp(unit.Dp(150))
-
-							if len(a.fileItems) == 0 {
-								// Show placeholder text
-								return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-									label := material.Body2(a.theme, "No files selected")
-									return label.Layout(gtx)
-								})
-							}
+		// Files label
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(5)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				label := material.Body1(a.theme, fmt.Sprintf("Selected files (%d):", len(a.fileItems)))
+				return label.Layout(gtx)
+			})
+		}),
+
+		// Files list area
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				border := widget.Border{
+					Color:        a.theme.Fg,
+					CornerRadius: unit.Dp(4),
+					Width:        unit.Dp(1),
+				}
+				return border.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					return layout.Inset{
+						Top:    unit.Dp(8),
+						Bottom: unit.Dp(8),
+						Left:   unit.Dp(8),
+						Right:  unit.Dp(8),
+					}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+						// Set fixed height for the list
+						gtx.Constraints.Min.Y = gtx.Dp(unit.Dp(230))
+						gtx.Constraints.Max.Y = gtx.Dp(unit.Dp(230))
 
-							// Show scrollable list of files
-							return material.List(a.theme, &a.list).Layout(gtx, len(a.fileItems), func(gtx layout.Context, index int) layout.Dimensions {
-								item := a.fileItems[index]
-								return layout.Inset{Bottom: unit.Dp(4)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-									return layout.Flex{
-										Axis:      layout.Horizontal,
-										Alignment: layout.Middle,
-										Spacing:   layout.SpaceBetween,
-									}.Layout(gtx,
-										// Filename
-										layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
-											label := material.Body2(a.theme, filepath.Base(item.path))
-											return label.Layout(gtx)
-										}),
-										// Remove button
-										layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-											btn := material.Button(a.theme, &item.removeBtn, "✕")
-											btn.CornerRadius = unit.Dp(4)
-											btn.Inset = layout.Inset{
-												Top:    unit.Dp(4),
-												Bottom: unit.Dp(4),
-												Left:   unit.Dp(8),
-												Right:  unit.Dp(8),
-											}
-											return btn.Layout(gtx)
-										}),
-									)
-								})
+						if len(a.fileItems) == 0 {
+							// Show placeholder text
+							return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+								label := material.Body2(a.theme, "No files selected")
+								return label.Layout(gtx)
 							})
-						})
+						}
+
+						// Show the files with their conversion results
+						return a.layoutFileTable(gtx)
 					})
 				})
-			}),
+			})
+		}),
 
-			// Button row
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Inset{Bottom: unit.Dp(15)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-					return layout.Flex{
-						Axis:    layout.Horizontal,
-						Spacing: layout.SpaceBetween,
-					}.Layout(gtx,
-						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-							btn := material.Button(a.theme, &a.browseBtn, "Add File...")
+		// Button row
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(15)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{
+					Axis:    layout.Horizontal,
+					Spacing: layout.SpaceBetween,
+				}.Layout(gtx,
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						btn := material.Button(a.theme, &a.browseBtn, "Add File...")
+						btn.CornerRadius = unit.Dp(4)
+						return btn.Layout(gtx)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Left: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							btn := material.Button(a.theme, &a.addFolderBtn, "Add Folder...")
 							btn.CornerRadius = unit.Dp(4)
 							return btn.Layout(gtx)
-						}),
-						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-							return layout.Inset{Left: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-								btn := material.Button(a.theme, &a.clearBtn, "Clear All")
-								btn.CornerRadius = unit.Dp(4)
-								return btn.Layout(gtx)
-							})
-						}),
-					)
-				})
-			}),
+						})
+					}),
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						return layout.Dimensions{Size: gtx.Constraints.Min}
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Left: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							if a.processing || !a.hasFailed() {
+								gtx = gtx.Disabled()
+							}
+							btn := material.Button(a.theme, &a.retryBtn, "Retry Failed")
+							btn.CornerRadius = unit.Dp(4)
+							return btn.Layout(gtx)
+						})
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Left: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							if a.processing {
+								gtx = gtx.Disabled()
+							}
+							btn := material.Button(a.theme, &a.clearBtn, "Clear All")
+							btn.CornerRadius = unit.Dp(4)
+							return btn.Layout(gtx)
+						})
+					}),
+				)
+			})
+		}),
+	)
+}
 
-			// Output directory label
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Inset{Bottom: unit.Dp(5)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-					label := material.Body1(a.theme, "Output directory (optional):")
-					return label.Layout(gtx)
-				})
-			}),
+// layoutSettings draws the conversion settings in a scrolling list.
+func (a *App) layoutSettings(gtx layout.Context) layout.Dimensions {
+	rows := []layout.Widget{
+		// Folder import filters
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(15)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{
+					Axis:      layout.Horizontal,
+					Alignment: layout.Middle,
+				}.Layout(gtx,
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						return a.layoutEditor(gtx, &a.includePatterns, "Include, e.g. *.jpg, *.png")
+					}),
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							return a.layoutEditor(gtx, &a.excludePatterns, "Exclude, e.g. thumbs, *_small.*")
+						})
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							return material.CheckBox(a.theme, &a.followSymlinks, "Follow symlinks").Layout(gtx)
+						})
+					}),
+				)
+			})
+		},
 
-			// Output directory with browse button
+		// Output directory label
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(5)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				label := material.Body1(a.theme, "Output directory (optional):")
+				return label.Layout(gtx)
+			})
+		},
+
+		// Output directory with browse button
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(15)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{
+					Axis:    layout.Horizontal,
+					Spacing: layout.SpaceBetween,
+				}.Layout(gtx,
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						return a.layoutEditor(gtx, &a.outputDir, "Leave empty to save next to originals")
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							btn := material.Button(a.theme, &a.browseDirBtn, "Browse...")
+							btn.CornerRadius = unit.Dp(4)
+							return btn.Layout(gtx)
+						})
+					}),
+				)
+			})
+		},
+
+		// Mirror source folders under the output directory
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(15)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				// Relative paths only matter when writing to an output directory
+				if a.outputDir.Text() == "" {
+					gtx = gtx.Disabled()
+				}
+				return layout.Flex{
+					Axis:      layout.Horizontal,
+					Alignment: layout.Middle,
+				}.Layout(gtx,
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return material.CheckBox(a.theme, &a.preservePaths, "Preserve relative paths from").Layout(gtx)
+					}),
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							return a.layoutEditor(gtx, &a.sourceRoot, "Source root (default: common folder)")
+						})
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							btn := material.Button(a.theme, &a.browseRootBtn, "Browse...")
+							btn.CornerRadius = unit.Dp(4)
+							return btn.Layout(gtx)
+						})
+					}),
+				)
+			})
+		},
+
+		// Output file name template and collision handling
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{
+					Axis:      layout.Horizontal,
+					Alignment: layout.Middle,
+				}.Layout(gtx,
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						label := material.Body1(a.theme, "File names:")
+						return label.Layout(gtx)
+					}),
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							return a.layoutEditor(gtx, &a.nameTemplate, "{name} {width} {height} {quality} {hash} {date} ...")
+						})
+					}),
+				)
+			})
+		},
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(15)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layoutRadioRow(gtx, a.theme, "If file exists:", &a.collision, controllers.CollisionPolicies, collisionLabels)
+			})
+		},
+
+		// Skip files converted by an earlier run
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(15)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layoutRadioRow(gtx, a.theme, "Skip up-to-date:", &a.incremental, controllers.IncrementalModes, incrementalLabels)
+			})
+		},
+
+		// Outputs that came out bigger than their source
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(15)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layoutRadioRow(gtx, a.theme, "If WebP is larger:", &a.larger, controllers.LargerPolicies, largerLabels)
+			})
+		},
+
+		// Quality slider and size estimate, with the number of files
+		// converted at once beside them
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+					layout.Flexed(1, a.layoutQuality),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Left: unit.Dp(20)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							return layout.Flex{
+								Axis:      layout.Horizontal,
+								Alignment: layout.Middle,
+							}.Layout(gtx,
+								layout.Rigid(material.Body1(a.theme, "Concurrent conversions:").Layout),
+								layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+									return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+										gtx.Constraints.Min.X = gtx.Dp(unit.Dp(70))
+										gtx.Constraints.Max.X = gtx.Constraints.Min.X
+										return a.layoutEditor(gtx, &a.concurrency, strconv.Itoa(controllers.DefaultConcurrency()))
+									})
+								}),
+							)
+						})
+					}),
+				)
+			})
+		},
+
+		// Target size and SSIM labels
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(5)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						label := material.Body1(a.theme, "Target size (optional):")
+						return label.Layout(gtx)
+					}),
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							label := material.Body1(a.theme, "Min SSIM (optional):")
+							return label.Layout(gtx)
+						})
+					}),
+				)
+			})
+		},
+
+		// Target size and SSIM editors
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						if a.mode.Value == modeLossless {
+							gtx = gtx.Disabled()
+						}
+						return a.layoutEditor(gtx, &a.targetSize, "e.g. 200KB")
+					}),
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						if a.mode.Value == modeLossless {
+							gtx = gtx.Disabled()
+						}
+						return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							return a.layoutEditor(gtx, &a.minSSIM, "e.g. 0.95")
+						})
+					}),
+				)
+			})
+		},
+
+		// Encoding mode
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{
+					Axis:      layout.Horizontal,
+					Alignment: layout.Middle,
+				}.Layout(gtx,
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return material.RadioButton(a.theme, &a.mode, modeLossy, "Lossy").Layout(gtx)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							return material.RadioButton(a.theme, &a.mode, modeLossless, "Lossless").Layout(gtx)
+						})
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Left: unit.Dp(20)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							// Exact only applies to lossless encoding
+							if a.mode.Value != modeLossless {
+								gtx = gtx.Disabled()
+							}
+							return material.CheckBox(a.theme, &a.exact, "Exact (keep RGB under transparent pixels)").Layout(gtx)
+						})
+					}),
+				)
+			})
+		},
+
+		// Metadata policy
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(15)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layoutRadioRow(gtx, a.theme, "Metadata:", &a.metadata, controllers.MetadataPolicies, metadataLabels)
+			})
+		},
+
+		// Resize label
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(5)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				label := material.Body1(a.theme, "Resize (optional, max width x height in px):")
+				return label.Layout(gtx)
+			})
+		},
+
+		// Resize dimensions
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(5)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						return a.layoutEditor(gtx, &a.maxWidth, "Max width")
+					}),
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							return a.layoutEditor(gtx, &a.maxHeight, "Max height")
+						})
+					}),
+				)
+			})
+		},
+
+		// Resize mode and resampling kernel
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(20)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				children := make([]layout.FlexChild, 0, len(controllers.ResizeModes)+len(controllers.Resamplers))
+				for _, mode := range controllers.ResizeModes {
+					children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return material.RadioButton(a.theme, &a.resizeMode, string(mode), resizeModeLabels[mode]).Layout(gtx)
+					}))
+				}
+				for i, resampler := range controllers.Resamplers {
+					children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						inset := layout.Inset{}
+						if i == 0 {
+							inset.Left = unit.Dp(20)
+						}
+						return inset.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							return material.RadioButton(a.theme, &a.resampler, string(resampler), resamplerLabels[resampler]).Layout(gtx)
+						})
+					}))
+				}
+				return layout.Flex{
+					Axis:      layout.Horizontal,
+					Alignment: layout.Middle,
+				}.Layout(gtx, children...)
+			})
+		},
+	}
+	return material.List(a.theme, &a.settingsList).Layout(gtx, len(rows), func(gtx layout.Context, i int) layout.Dimensions {
+		return rows[i](gtx)
+	})
+}
+
+// layoutActions draws the convert, cancel and report buttons with the
+// progress and status of the batch.
+func (a *App) layoutActions(gtx layout.Context) layout.Dimensions {
+	return layout.Inset{Top: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			// Convert and cancel buttons
 			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 				return layout.Inset{Bottom: unit.Dp(15)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 					return layout.Flex{
@@ -242,25 +716,31 @@ func (a *App) Layout(gtx layout.Context) layout.Dimensions {
 						Spacing: layout.SpaceBetween,
 					}.Layout(gtx,
 						layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
-							a.outputDir.SingleLine = true
-							editor := material.Editor(a.theme, &a.outputDir, "Leave empty to save next to originals")
-							border := widget.Border{
-								Color:        a.theme.Fg,
-								CornerRadius: unit.Dp(4),
-								Width:        unit.Dp(1),
+							btnText := "Convert All to WebP"
+							if a.processing {
+								btnText = "Converting..."
 							}
-							return border.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-								return layout.Inset{
-									Top:    unit.Dp(8),
-									Bottom: unit.Dp(8),
-									Left:   unit.Dp(8),
-									Right:  unit.Dp(8),
-								}.Layout(gtx, editor.Layout)
+							btn := material.Button(a.theme, &a.convertBtn, btnText)
+							btn.CornerRadius = unit.Dp(4)
+							return btn.Layout(gtx)
+						}),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+								// Cancel is only active while a batch is running
+								if !a.processing {
+									gtx = gtx.Disabled()
+								}
+								btn := material.Button(a.theme, &a.cancelBtn, "Cancel")
+								btn.CornerRadius = unit.Dp(4)
+								return btn.Layout(gtx)
 							})
 						}),
 						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 							return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-								btn := material.Button(a.theme, &a.browseDirBtn, "Browse...")
+								if a.processing || len(a.reportEntries()) == 0 {
+									gtx = gtx.Disabled()
+								}
+								btn := material.Button(a.theme, &a.saveReportBtn, "Save Report...")
 								btn.CornerRadius = unit.Dp(4)
 								return btn.Layout(gtx)
 							})
@@ -269,47 +749,8 @@ func (a *App) Layout(gtx layout.Context) layout.Dimensions {
 				})
 			}),
 
-			// Quality label
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Inset{Bottom: unit.Dp(5)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-					label := material.Body1(a.theme, "Quality (1-100):")
-					return label.Layout(gtx)
-				})
-			}),
-
-			// Quality editor
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Inset{Bottom: unit.Dp(20)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-					a.quality.SingleLine = true
-					editor := material.Editor(a.theme, &a.quality, "80")
-					border := widget.Border{
-						Color:        a.theme.Fg,
-						CornerRadius: unit.Dp(4),
-						Width:        unit.Dp(1),
-					}
-					return border.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-						return layout.Inset{
-							Top:    unit.Dp(8),
-							Bottom: unit.Dp(8),
-							Left:   unit.Dp(8),
-							Right:  unit.Dp(8),
-						}.Layout(gtx, editor.Layout)
-					})
-				})
-			}),
-
-			// Convert button
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Inset{Bottom: unit.Dp(15)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-					btnText := "Convert All to WebP"
-					if a.processing {
-						btnText = "Converting..."
-					}
-					btn := material.Button(a.theme, &a.convertBtn, btnText)
-					btn.CornerRadius = unit.Dp(4)
-					return btn.Layout(gtx)
-				})
-			}),
+			// Progress of the running batch
+			layout.Rigid(a.layoutProgress),
 
 			// Status text
 			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
@@ -320,35 +761,104 @@ func (a *App) Layout(gtx layout.Context) layout.Dimensions {
 	})
 }
 
+// searchesQuality reports whether a target size or minimum SSIM replaces the
+// fixed quality.
+func (a *App) searchesQuality() bool {
+	return strings.TrimSpace(a.targetSize.Text()) != "" || strings.TrimSpace(a.minSSIM.Text()) != ""
+}
+
+// layoutRadioRow draws label followed by a radio button for each of values,
+// captioned from labels.
+func layoutRadioRow[T ~string](gtx layout.Context, th *material.Theme, label string, enum *widget.Enum, values []T, labels map[T]string) layout.Dimensions {
+	children := []layout.FlexChild{
+		layout.Rigid(material.Body1(th, label).Layout),
+	}
+	for _, value := range values {
+		children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return material.RadioButton(th, enum, string(value), labels[value]).Layout(gtx)
+			})
+		}))
+	}
+	return layout.Flex{
+		Axis:      layout.Horizontal,
+		Alignment: layout.Middle,
+	}.Layout(gtx, children...)
+}
+
+// layoutEditor draws a bordered single-line editor.
+func (a *App) layoutEditor(gtx layout.Context, e *widget.Editor, hint string) layout.Dimensions {
+	e.SingleLine = true
+	editor := material.Editor(a.theme, e, hint)
+	border := widget.Border{
+		Color:        a.theme.Fg,
+		CornerRadius: unit.Dp(4),
+		Width:        unit.Dp(1),
+	}
+	return border.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Inset{
+			Top:    unit.Dp(8),
+			Bottom: unit.Dp(8),
+			Left:   unit.Dp(8),
+			Right:  unit.Dp(8),
+		}.Layout(gtx, editor.Layout)
+	})
+}
+
 func (a *App) browseFiles(w *app.Window) {
 	filename, err := dialog.File().
 		Title("Select Image to Add (click Add File again for more)").
-		Filter("Image Files", "jpg", "jpeg", "png", "bmp").
+		Filter("Image Files", controllers.SupportedExtensions...).
 		Filter("All Files", "*").
 		Load()
 
 	if err != nil {
 		if err.Error() != "Cancelled" {
-			a.statusText = fmt.Sprintf("Error opening file dialog: %v", err)
-			w.Invalidate()
+			a.post(w, statusEvent(fmt.Sprintf("Error opening file dialog: %v", err)))
 		}
 		return
 	}
 
-	// Check for duplicates
-	for _, item := range a.fileItems {
-		if item.path == filename {
-			a.statusText = "File already in list"
-			w.Invalidate()
-			return
+	a.post(w, fileAddedEvent{item: newFileItem(filename)})
+}
+
+// browseFolder adds the files under a chosen folder that match opts, which
+// the caller reads from the filter settings.
+func (a *App) browseFolder(w *app.Window, opts controllers.ScanOptions) {
+	directory, err := dialog.Directory().
+		Title("Select Folder to Add").
+		Browse()
+
+	if err != nil {
+		if err.Error() != "Cancelled" {
+			a.post(w, statusEvent(fmt.Sprintf("Error opening directory dialog: %v", err)))
 		}
+		return
 	}
 
-	// Add file to the list
-	a.fileItems = append(a.fileItems, &FileItem{path: filename})
+	a.post(w, statusEvent(fmt.Sprintf("Scanning %s...", directory)))
 
-	a.statusText = fmt.Sprintf("Added: %s (Total: %d files). Click Add File to add more.", filepath.Base(filename), len(a.fileItems))
-	w.Invalidate()
+	paths, warnings, err := controllers.ScanDirectory(directory, opts)
+	if err != nil {
+		a.post(w, statusEvent(fmt.Sprintf("Error: %v", err)))
+		return
+	}
+
+	items := make([]*FileItem, len(paths))
+	for i, path := range paths {
+		items[i] = newFileItem(path)
+	}
+	a.post(w, folderAddedEvent{folder: directory, items: items, unreadable: warnings})
+}
+
+// hasFile reports whether path is already in the file list.
+func (a *App) hasFile(path string) bool {
+	for _, item := range a.fileItems {
+		if item.path == path {
+			return true
+		}
+	}
+	return false
 }
 
 func (a *App) browseDirectory(w *app.Window) {
@@ -358,137 +868,285 @@ func (a *App) browseDirectory(w *app.Window) {
 
 	if err != nil {
 		if err.Error() != "Cancelled" {
-			a.statusText = fmt.Sprintf("Error opening directory dialog: %v", err)
-			w.Invalidate()
+			a.post(w, statusEvent(fmt.Sprintf("Error opening directory dialog: %v", err)))
+		}
+		return
+	}
+
+	a.post(w, outputDirEvent(directory))
+}
+
+func (a *App) browseSourceRoot(w *app.Window) {
+	directory, err := dialog.Directory().
+		Title("Select Source Root").
+		Browse()
+
+	if err != nil {
+		if err.Error() != "Cancelled" {
+			a.post(w, statusEvent(fmt.Sprintf("Error opening directory dialog: %v", err)))
 		}
 		return
 	}
 
-	a.outputDir.SetText(directory)
-	a.statusText = fmt.Sprintf("Output directory: %s", directory)
-	w.Invalidate()
+	a.post(w, sourceRootEvent(directory))
 }
 
-func (a *App) convertImages(w *app.Window) {
-	if len(a.fileItems) == 0 {
+// reportEntries describes every file with a conversion result, in list
+// order.
+func (a *App) reportEntries() []controllers.ReportEntry {
+	var entries []controllers.ReportEntry
+	for _, item := range a.fileItems {
+		if item.status == statusPending || item.status == statusConverting {
+			continue
+		}
+		entries = append(entries, controllers.NewReportEntry(item.path, item.report, item.err))
+	}
+	return entries
+}
+
+func (a *App) saveReport(w *app.Window, entries []controllers.ReportEntry) {
+	filename, err := dialog.File().
+		Title("Save Report").
+		Filter("JSON Report", "json").
+		Filter("CSV Report", "csv").
+		Save()
+
+	if err != nil {
+		if err.Error() != "Cancelled" {
+			a.post(w, statusEvent(fmt.Sprintf("Error opening file dialog: %v", err)))
+		}
+		return
+	}
+
+	if filepath.Ext(filename) == "" {
+		filename += ".json"
+	}
+	if err := controllers.SaveReport(filename, entries); err != nil {
+		a.post(w, statusEvent(fmt.Sprintf("Error: %v", err)))
+		return
+	}
+
+	a.post(w, statusEvent(fmt.Sprintf("Report saved to %s", filename)))
+}
+
+// encodeOptions reads the settings that decide how images are encoded.
+func (a *App) encodeOptions() (controllers.Options, error) {
+	opts := controllers.DefaultOptions()
+	if a.mode.Value != modeLossless && !a.searchesQuality() {
+		opts.Quality = float32(a.qualityValue())
+	}
+	opts.Lossless = a.mode.Value == modeLossless
+	opts.Exact = opts.Lossless && a.exact.Value
+
+	// Parse target size
+	if targetStr := strings.TrimSpace(a.targetSize.Text()); targetStr != "" && !opts.Lossless {
+		size, err := controllers.ParseSize(targetStr)
+		if err != nil {
+			return opts, fmt.Errorf("target size must be a size such as 200KB")
+		}
+		opts.TargetSize = size
+	}
+
+	// Parse minimum SSIM
+	if ssimStr := strings.TrimSpace(a.minSSIM.Text()); ssimStr != "" && !opts.Lossless {
+		score, err := strconv.ParseFloat(ssimStr, 64)
+		if err != nil || score <= 0 || score >= 1 {
+			return opts, fmt.Errorf("minimum SSIM must be between 0 and 1")
+		}
+		if opts.TargetSize > 0 {
+			return opts, fmt.Errorf("choose either a target size or a minimum SSIM")
+		}
+		opts.MinSSIM = score
+	}
+
+	opts.Metadata = controllers.MetadataPolicy(a.metadata.Value)
+
+	// Parse resize settings
+	opts.Resize = controllers.ResizeOptions{
+		Mode:      controllers.ResizeMode(a.resizeMode.Value),
+		Resampler: controllers.Resampler(a.resampler.Value),
+	}
+	for _, dim := range []struct {
+		text  string
+		value *int
+	}{
+		{a.maxWidth.Text(), &opts.Resize.MaxWidth},
+		{a.maxHeight.Text(), &opts.Resize.MaxHeight},
+	} {
+		if dim.text == "" {
+			continue
+		}
+		n, err := strconv.Atoi(dim.text)
+		if err != nil || n < 1 {
+			return opts, fmt.Errorf("resize dimensions must be positive numbers")
+		}
+		*dim.value = n
+	}
+	return opts, opts.Resize.Validate()
+}
+
+// convertImages starts converting items, which are a subset of the file list
+// when retrying failed files. It reads the settings on the UI goroutine and
+// converts a snapshot of items in the background, so the list can change
+// while the batch runs.
+func (a *App) convertImages(w *app.Window, items []*FileItem) {
+	if len(items) == 0 {
 		a.statusText = "Error: No files selected"
-		w.Invalidate()
 		return
 	}
 
-	qualityStr := a.quality.Text()
+	concurrencyStr := a.concurrency.Text()
 	outputDir := a.outputDir.Text()
+	// The preserve checkbox is disabled, not cleared, without an output
+	// directory
+	layoutOpts := controllers.OutputLayout{
+		Dir:              outputDir,
+		PreserveRelative: a.preservePaths.Value && outputDir != "",
+		SourceRoot:       a.sourceRoot.Text(),
+		Template:         strings.TrimSpace(a.nameTemplate.Text()),
+	}
+	if err := layoutOpts.Validate(); err != nil {
+		a.statusText = fmt.Sprintf("Error: %v", err)
+		return
+	}
+
+	opts, err := a.encodeOptions()
+	if err != nil {
+		a.statusText = fmt.Sprintf("Error: %v", err)
+		return
+	}
+	opts.Collision = controllers.CollisionPolicy(a.collision.Value)
+	opts.Incremental = controllers.IncrementalMode(a.incremental.Value)
+	opts.Larger = controllers.LargerPolicy(a.larger.Value)
 
-	// Parse quality
-	var quality float32 = 80
-	if qualityStr != "" {
-		var q int
-		_, err := fmt.Sscanf(qualityStr, "%d", &q)
-		if err != nil || q < 1 || q > 100 {
-			a.statusText = "Error: Quality must be between 1 and 100"
-			w.Invalidate()
+	// Parse concurrency
+	concurrency := controllers.DefaultConcurrency()
+	if concurrencyStr != "" {
+		c, err := strconv.Atoi(concurrencyStr)
+		if err != nil || c < 1 {
+			a.statusText = "Error: Concurrent conversions must be at least 1"
 			return
 		}
-		quality = float32(q)
+		concurrency = c
 	}
 
 	// Validate output directory if specified
 	if outputDir != "" {
 		if info, err := os.Stat(outputDir); err != nil || !info.IsDir() {
 			a.statusText = "Error: Invalid output directory"
-			w.Invalidate()
 			return
 		}
 	}
 
-	a.processing = true
-	a.statusText = "Converting files..."
-	w.Invalidate()
-
-	// Convert files with progress tracking
-	var wg sync.WaitGroup
-	results := make(chan string, len(a.fileItems))
-
-	for i, item := range a.fileItems {
-		wg.Add(1)
-		go func(path string, index int) {
-			defer wg.Done()
-
-			// Determine output path
-			var outputPath string
-			if outputDir != "" {
-				base := filepath.Base(path)
-				ext := filepath.Ext(base)
-				name := strings.TrimSuffix(base, ext) + ".webp"
-				outputPath = filepath.Join(outputDir, name)
-			} else {
-				ext := filepath.Ext(path)
-				outputPath = strings.TrimSuffix(path, ext) + ".webp"
-			}
+	// Mirror relative to the folder the files share unless a root is given
+	if layoutOpts.PreserveRelative && layoutOpts.SourceRoot == "" {
+		paths := make([]string, len(a.fileItems))
+		for i, item := range a.fileItems {
+			paths[i] = item.path
+		}
+		layoutOpts.SourceRoot = controllers.CommonDir(paths)
+	}
 
-			// Convert the image
-			err := convertImage(path, outputPath, quality)
-			if err != nil {
-				results <- fmt.Sprintf("❌ %s: %v", filepath.Base(path), err)
-			} else {
-				results <- fmt.Sprintf("✓ %s", filepath.Base(path))
-			}
+	if opts.Incremental == controllers.IncrementalStrict {
+		paths := make([]string, len(a.fileItems))
+		for i, item := range a.fileItems {
+			paths[i] = item.path
+		}
+		manifest, err := controllers.LoadManifest(controllers.ManifestPath(layoutOpts, paths))
+		if err != nil {
+			a.statusText = fmt.Sprintf("Error: %v", err)
+			return
+		}
+		opts.Manifest = manifest
+	}
 
-			// Update progress
-			a.statusText = fmt.Sprintf("Converting... %d/%d", index+1, len(a.fileItems))
-			w.Invalidate()
-		}(item.path, i)
+	jobs := make([]controllers.Job, len(items))
+	for i, item := range items {
+		outputDir, outputPath, err := layoutOpts.Path(item.path)
+		if err != nil {
+			a.statusText = fmt.Sprintf("Error: %v", err)
+			return
+		}
+		jobs[i] = controllers.Job{
+			Index:      i,
+			InputPath:  item.path,
+			OutputDir:  outputDir,
+			OutputPath: outputPath,
+		}
 	}
 
-	// Wait for all conversions to complete
-	wg.Wait()
-	close(results)
+	ctx, cancel := context.WithCancel(context.Background())
 
-	// Collect results
-	successCount := 0
-	var resultsSummary strings.Builder
-	for result := range results {
-		if strings.HasPrefix(result, "✓") {
-			successCount++
-		}
-		resultsSummary.WriteString(result)
-		resultsSummary.WriteString("\n")
+	items = slices.Clone(items)
+	for _, item := range items {
+		item.status = statusConverting
+		item.report = controllers.Report{}
+		item.err = nil
 	}
 
-	a.processing = false
-	a.statusText = fmt.Sprintf("Complete! %d/%d files converted successfully", successCount, len(a.fileItems))
-	w.Invalidate()
+	a.processing = true
+	a.cancel = cancel
+	a.progress = newBatchProgress(items)
+	a.statusText = "Converting files..."
+	batch := controllers.BatchOptions{
+		Concurrency: concurrency,
+		OnStart: func(job controllers.Job) {
+			a.post(w, jobStartedEvent(job.InputPath))
+		},
+	}
 
-	log.Println(resultsSummary.String())
+	go a.runBatch(w, ctx, cancel, items, jobs, opts, batch)
 }
 
-func convertImage(inputPath, outputPath string, quality float32) error {
-	// Open input file
-	file, err := os.Open(inputPath)
-	if err != nil {
-		return fmt.Errorf("opening file: %w", err)
-	}
-	defer file.Close()
+// runBatch converts jobs, the files in items, on a bounded worker pool and
+// posts their results as they finish.
+func (a *App) runBatch(w *app.Window, ctx context.Context, cancel context.CancelFunc, items []*FileItem, jobs []controllers.Job, opts controllers.Options, batch controllers.BatchOptions) {
+	defer cancel()
 
-	// Decode image
-	img, err := controllers.DecodeImage(file, inputPath)
+	successCount := 0
+	skippedCount := 0
+	var total savings
+	var resultsSummary strings.Builder
+	for result := range a.converter.ConvertBatch(ctx, jobs, opts, batch) {
+		if errors.Is(result.Err, context.Canceled) {
+			fmt.Fprintf(&resultsSummary, "⏹ %s: cancelled\n", filepath.Base(result.InputPath))
+		} else if result.Err != nil {
+			fmt.Fprintf(&resultsSummary, "❌ %s: %v\n", filepath.Base(result.InputPath), result.Err)
+		} else if result.Report.Skipped {
+			skippedCount++
+			fmt.Fprintln(&resultsSummary, resultLine(filepath.Base(result.InputPath), result.Report, opts))
+		} else {
+			successCount++
+			total.add(result.Report)
+			fmt.Fprintln(&resultsSummary, resultLine(filepath.Base(result.InputPath), result.Report, opts))
+		}
 
-	if err != nil {
-		return fmt.Errorf("decoding image: %w", err)
+		a.post(w, resultEvent{item: items[result.Index], result: result})
 	}
 
-	// Create output file
-	outFile, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("creating output: %w", err)
+	// Keep what finished, even when cancelled
+	if opts.Manifest != nil {
+		if err := opts.Manifest.Save(); err != nil {
+			a.post(w, batchDoneEvent{status: fmt.Sprintf("Error: %v", err)})
+			log.Println(resultsSummary.String())
+			return
+		}
 	}
-	defer outFile.Close()
 
-	// Encode as WebP
-	err = webp.Encode(outFile, *img, &webp.Options{Quality: quality})
-	if err != nil {
-		return fmt.Errorf("encoding webp: %w", err)
+	var status string
+	if ctx.Err() != nil {
+		status = fmt.Sprintf("Cancelled. %d/%d files converted before cancelling", successCount, len(jobs))
+	} else {
+		status = fmt.Sprintf("Complete! %d/%d files converted successfully", successCount, len(jobs))
+		if skippedCount > 0 {
+			status += fmt.Sprintf(", %d skipped", skippedCount)
+		}
+		if successCount > 0 {
+			status += fmt.Sprintf(", %s", total)
+		}
 	}
+	a.post(w, batchDoneEvent{status: status})
 
-	return nil
+	fmt.Fprintf(&resultsSummary, "Total: %s", total)
+	log.Println(resultsSummary.String())
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/unit"
+	"gioui.org/widget/material"
+	"github.com/Sakaino2/image-compressor/controllers"
+)
+
+// batchProgress tracks a running batch for the progress bar. It belongs to
+// the UI goroutine and is updated by events.
+type batchProgress struct {
+	start time.Time
+
+	files, done      int
+	bytes, doneBytes int64
+
+	// pixels counts the pixels encoded so far
+	pixels int64
+
+	// active lists the files being encoded, oldest first
+	active []string
+}
+
+func newBatchProgress(items []*FileItem) *batchProgress {
+	p := &batchProgress{start: time.Now(), files: len(items)}
+	for _, item := range items {
+		p.bytes += item.size
+	}
+	return p
+}
+
+// started notes that a worker began encoding path.
+func (p *batchProgress) started(path string) {
+	p.active = append(p.active, path)
+}
+
+// finished counts result, the outcome of item. It must be called before the
+// result is recorded on item, so the item's size matches the total.
+func (p *batchProgress) finished(item *FileItem, result controllers.Result) {
+	p.done++
+	p.doneBytes += item.size
+	if result.Err == nil && !result.Report.Skipped {
+		p.pixels += int64(result.Report.Width) * int64(result.Report.Height)
+	}
+	if i := slices.Index(p.active, result.InputPath); i >= 0 {
+		p.active = slices.Delete(p.active, i, i+1)
+	}
+}
+
+// fraction returns how much of the batch is done, weighted by file size so
+// one large photo counts for more than many icons.
+func (p *batchProgress) fraction() float32 {
+	switch {
+	case p.bytes > 0:
+		return float32(p.doneBytes) / float32(p.bytes)
+	case p.files > 0:
+		return float32(p.done) / float32(p.files)
+	}
+	return 1
+}
+
+// remaining estimates the time left from the throughput so far. It returns
+// false until a file has finished.
+func (p *batchProgress) remaining(elapsed time.Duration) (time.Duration, bool) {
+	f := float64(p.fraction())
+	if p.done == 0 || f <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(elapsed) * (1 - f) / f), true
+}
+
+// summary describes the progress after elapsed, e.g.
+// "3/10 files, 45%, 0:12 elapsed, about 0:15 left, 2.1 files/s, 14.3 MP/s".
+func (p *batchProgress) summary(elapsed time.Duration) string {
+	line := fmt.Sprintf("%d/%d files, %.0f%%, %s elapsed", p.done, p.files, p.fraction()*100, formatClock(elapsed))
+	if left, ok := p.remaining(elapsed); ok {
+		line += fmt.Sprintf(", about %s left", formatClock(left))
+	}
+	if seconds := elapsed.Seconds(); seconds > 0 && p.done > 0 {
+		line += fmt.Sprintf(", %.1f files/s, %.1f MP/s", float64(p.done)/seconds, float64(p.pixels)/1e6/seconds)
+	}
+	return line
+}
+
+// current names the file being encoded, e.g. "Encoding IMG_0001.jpg and 3
+// more".
+func (p *batchProgress) current() string {
+	if len(p.active) == 0 {
+		return ""
+	}
+	line := "Encoding " + filepath.Base(p.active[len(p.active)-1])
+	if more := len(p.active) - 1; more > 0 {
+		line += fmt.Sprintf(" and %d more", more)
+	}
+	return line
+}
+
+// formatClock formats d as m:ss, or h:mm:ss from an hour.
+func formatClock(d time.Duration) string {
+	s := int(d.Round(time.Second).Seconds())
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// layoutProgress draws the progress bar of the running batch with its
+// timing, throughput and current file.
+func (a *App) layoutProgress(gtx layout.Context) layout.Dimensions {
+	p := a.progress
+	if p == nil {
+		return layout.Dimensions{}
+	}
+
+	// Redraw every second so the elapsed time keeps moving
+	gtx.Execute(op.InvalidateCmd{At: gtx.Now.Add(time.Second)})
+	elapsed := gtx.Now.Sub(p.start)
+
+	return layout.Inset{Bottom: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				bar := material.ProgressBar(a.theme, p.fraction())
+				bar.Height = unit.Dp(8)
+				return layout.Inset{Bottom: unit.Dp(4)}.Layout(gtx, bar.Layout)
+			}),
+			layout.Rigid(material.Caption(a.theme, p.summary(elapsed)).Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				label := material.Caption(a.theme, p.current())
+				label.MaxLines = 1
+				return label.Layout(gtx)
+			}),
+		)
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Sakaino2/image-compressor/controllers"
+)
+
+// resultLine describes a finished conversion for the batch summary, calling
+// the source name. It does not handle errors.
+func resultLine(name string, report controllers.Report, opts controllers.Options) string {
+	switch {
+	case report.UpToDate:
+		return fmt.Sprintf("⏭ %s: up to date", name)
+	case report.Skipped && report.Larger:
+		return fmt.Sprintf("⏭ %s: WebP would be larger than the original (%s)", name, controllers.FormatSize(report.InputBytes))
+	case report.Skipped:
+		return fmt.Sprintf("⏭ %s: %s already exists", name, report.OutputPath)
+	case report.Copied:
+		return fmt.Sprintf("↩ %s -> %s: WebP was larger, kept the original (%s)", name, report.OutputPath, controllers.FormatSize(report.InputBytes))
+	}
+
+	line := fmt.Sprintf("✓ %s -> %s (%s", name, report.OutputPath, sizeChange(report.InputBytes, report.OutputBytes))
+	switch {
+	case opts.MinSSIM > 0:
+		line += fmt.Sprintf(", quality %g, SSIM %.4f", report.Quality, report.SSIM)
+	case opts.TargetSize > 0:
+		line += fmt.Sprintf(", quality %g", report.Quality)
+	}
+	line += ")"
+	if report.Larger {
+		line = "⚠" + line[len("✓"):] + " larger than the original"
+	}
+	return line
+}
+
+// sizeChange formats a before and after size, e.g. "1.2 MB -> 300.0 KB, -75%".
+func sizeChange(before, after int64) string {
+	change := ""
+	if before > 0 {
+		change = fmt.Sprintf(", %+.0f%%", float64(after-before)*100/float64(before))
+	}
+	return fmt.Sprintf("%s -> %s%s", controllers.FormatSize(before), controllers.FormatSize(after), change)
+}
+
+// savings totals the bytes saved across a batch.
+type savings struct {
+	before, after int64
+}
+
+// add counts the WebP files written by report.
+func (s *savings) add(report controllers.Report) {
+	if report.Skipped || report.Copied {
+		return
+	}
+	s.before += report.InputBytes
+	s.after += report.OutputBytes
+}
+
+func (s savings) String() string {
+	saved := s.before - s.after
+	if saved < 0 {
+		return fmt.Sprintf("output grew by %s (%s)", controllers.FormatSize(-saved), sizeChange(s.before, s.after))
+	}
+	return fmt.Sprintf("saved %s (%s)", controllers.FormatSize(saved), sizeChange(s.before, s.after))
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget/material"
+	"github.com/Sakaino2/image-compressor/controllers"
+)
+
+// errorColor highlights failed conversions.
+var errorColor = color.NRGBA{R: 0xC6, G: 0x28, B: 0x28, A: 0xFF}
+
+// itemStatus is the conversion state of a FileItem.
+type itemStatus int
+
+const (
+	statusPending itemStatus = iota
+	statusConverting
+	statusDone
+	statusSkipped
+	statusFailed
+	statusCancelled
+)
+
+func (s itemStatus) icon() string {
+	switch s {
+	case statusConverting:
+		return "…"
+	case statusDone:
+		return "✓"
+	case statusSkipped:
+		return "⏭"
+	case statusFailed:
+		return "❌"
+	case statusCancelled:
+		return "⏹"
+	}
+	return "•"
+}
+
+// column is a sortable column of the file table.
+type column int
+
+// columnNone leaves the files in the order they were added.
+const columnNone column = -1
+
+const (
+	columnName column = iota
+	columnStatus
+	columnOriginal
+	columnOutput
+	columnRatio
+	columnQuality
+	columnSSIM
+	columnDimensions
+	columnTime
+	numColumns
+)
+
+var columnTitles = [numColumns]string{"File", "", "Original", "Output", "Ratio", "Quality", "SSIM", "W×H", "Time"}
+
+// columnWidths fixes the width of every column but the name, which takes the
+// remaining space.
+var columnWidths = [numColumns]unit.Dp{0, 28, 72, 72, 56, 60, 56, 84, 56}
+
+// newFileItem returns a pending FileItem for path.
+func newFileItem(path string) *FileItem {
+	item := &FileItem{path: path, warning: controllers.ExtensionMismatch(path)}
+	if info, err := os.Stat(path); err == nil {
+		item.size = info.Size()
+	}
+	return item
+}
+
+// setResult records the outcome of converting item.
+func (item *FileItem) setResult(result controllers.Result) {
+	item.report = result.Report
+	item.err = result.Err
+	if result.Report.InputBytes > 0 {
+		item.size = result.Report.InputBytes
+	}
+
+	switch {
+	case errors.Is(result.Err, context.Canceled):
+		item.status = statusCancelled
+	case result.Err != nil:
+		item.status = statusFailed
+	case result.Report.Skipped:
+		item.status = statusSkipped
+	default:
+		item.status = statusDone
+	}
+}
+
+// hasOutput reports whether the last conversion left an output file for
+// item, including one kept from an earlier run.
+func (item *FileItem) hasOutput() bool {
+	return item.status == statusDone || item.status == statusSkipped && item.report.OutputBytes > 0
+}
+
+// cell returns the text of item in column c.
+func (item *FileItem) cell(c column) string {
+	converted := item.hasOutput()
+	switch c {
+	case columnName:
+		return filepath.Base(item.path)
+	case columnStatus:
+		return item.status.icon()
+	case columnOriginal:
+		if item.size > 0 {
+			return controllers.FormatSize(item.size)
+		}
+	case columnOutput:
+		if converted {
+			return controllers.FormatSize(item.report.OutputBytes)
+		}
+	case columnRatio:
+		if converted && item.size > 0 {
+			return fmt.Sprintf("%.0f%%", float64(item.report.OutputBytes)*100/float64(item.size))
+		}
+	case columnQuality:
+		// A copied original was not encoded at any quality
+		if !converted || item.report.Copied {
+			break
+		}
+		if item.report.Lossless {
+			return "lossless"
+		}
+		if item.report.Quality > 0 {
+			return strconv.FormatFloat(float64(item.report.Quality), 'g', -1, 32)
+		}
+	case columnSSIM:
+		if converted && !item.report.Copied && item.report.SSIM > 0 {
+			return fmt.Sprintf("%.3f", item.report.SSIM)
+		}
+	case columnDimensions:
+		if converted && item.report.Width > 0 {
+			return fmt.Sprintf("%d×%d", item.report.Width, item.report.Height)
+		}
+	case columnTime:
+		if item.report.Duration > 0 {
+			return item.report.Duration.Round(10 * time.Millisecond).String()
+		}
+	}
+	return "—"
+}
+
+// details describes the source of item, e.g. "4032×3024, 2.1 MB".
+func (item *FileItem) details() string {
+	var parts []string
+	if item.width > 0 {
+		parts = append(parts, fmt.Sprintf("%d×%d", item.width, item.height))
+	}
+	if item.size > 0 {
+		parts = append(parts, controllers.FormatSize(item.size))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sortKey returns a value that orders items by column c.
+func (item *FileItem) sortKey(c column) float64 {
+	switch c {
+	case columnStatus:
+		return float64(item.status)
+	case columnOriginal:
+		return float64(item.size)
+	case columnOutput:
+		return float64(item.report.OutputBytes)
+	case columnRatio:
+		if item.size == 0 {
+			return 0
+		}
+		return float64(item.report.OutputBytes) / float64(item.size)
+	case columnQuality:
+		if item.report.Lossless {
+			// Above every lossy quality
+			return 101
+		}
+		return float64(item.report.Quality)
+	case columnSSIM:
+		return item.report.SSIM
+	case columnDimensions:
+		return float64(item.report.Width * item.report.Height)
+	case columnTime:
+		return float64(item.report.Duration)
+	}
+	return 0
+}
+
+// handleTableClicks sorts the file list when a column header is clicked.
+// Clicking the sorted column again reverses the order.
+func (a *App) handleTableClicks(gtx layout.Context) {
+	for c := column(0); c < numColumns; c++ {
+		if !a.headerBtns[c].Clicked(gtx) {
+			continue
+		}
+		if a.sortColumn == c {
+			a.sortDesc = !a.sortDesc
+		} else {
+			a.sortColumn, a.sortDesc = c, false
+		}
+		a.sortItems()
+	}
+}
+
+// sortItems orders the file list by the selected column.
+func (a *App) sortItems() {
+	c, desc := a.sortColumn, a.sortDesc
+	sort.SliceStable(a.fileItems, func(i, j int) bool {
+		x, y := a.fileItems[i], a.fileItems[j]
+		if desc {
+			x, y = y, x
+		}
+		if c == columnName {
+			return strings.ToLower(filepath.Base(x.path)) < strings.ToLower(filepath.Base(y.path))
+		}
+		return x.sortKey(c) < y.sortKey(c)
+	})
+}
+
+// hasFailed reports whether any file failed to convert.
+func (a *App) hasFailed() bool {
+	for _, item := range a.fileItems {
+		if item.status == statusFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// layoutTableRow lays out one row of the file table, with cells drawn by
+// cell and a trailing widget in the remove-button column.
+func layoutTableRow(gtx layout.Context, cell func(gtx layout.Context, c column) layout.Dimensions, trailing layout.Widget) layout.Dimensions {
+	children := make([]layout.FlexChild, 0, numColumns+1)
+	for c := column(0); c < numColumns; c++ {
+		if c == columnName {
+			children = append(children, layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+				return cell(gtx, c)
+			}))
+			continue
+		}
+		children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			width := gtx.Dp(columnWidths[c])
+			gtx.Constraints.Min.X, gtx.Constraints.Max.X = width, width
+			return layout.Inset{Left: unit.Dp(4)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return cell(gtx, c)
+			})
+		}))
+	}
+	children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+		width := gtx.Dp(unit.Dp(40))
+		gtx.Constraints.Min.X, gtx.Constraints.Max.X = width, width
+		return layout.E.Layout(gtx, trailing)
+	}))
+
+	return layout.Flex{
+		Axis:      layout.Horizontal,
+		Alignment: layout.Middle,
+	}.Layout(gtx, children...)
+}
+
+// layoutTableHeader draws the clickable column titles, marking the sorted
+// column with an arrow.
+func (a *App) layoutTableHeader(gtx layout.Context) layout.Dimensions {
+	return layoutTableRow(gtx, func(gtx layout.Context, c column) layout.Dimensions {
+		return a.headerBtns[c].Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			title := columnTitles[c]
+			if c == a.sortColumn {
+				if a.sortDesc {
+					title += " ▼"
+				} else {
+					title += " ▲"
+				}
+			}
+			label := material.Caption(a.theme, title)
+			label.MaxLines = 1
+			return label.Layout(gtx)
+		})
+	}, func(gtx layout.Context) layout.Dimensions {
+		return layout.Dimensions{}
+	})
+}
+
+// layoutTableItem draws one file, with its thumbnail beside the name.
+func (a *App) layoutTableItem(gtx layout.Context, item *FileItem) layout.Dimensions {
+	return layoutTableRow(gtx, func(gtx layout.Context, c column) layout.Dimensions {
+		if c != columnName {
+			label := material.Body2(a.theme, item.cell(c))
+			label.MaxLines = 1
+			if c == columnStatus && item.status == statusFailed {
+				label.Color = errorColor
+			}
+			return label.Layout(gtx)
+		}
+
+		// Clicking the file opens it in the comparison viewer
+		return item.selectBtn.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{
+				Axis:      layout.Horizontal,
+				Alignment: layout.Middle,
+			}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return layout.Inset{Right: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+						return a.layoutThumbnail(gtx, item)
+					})
+				}),
+				layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+					return a.layoutItemName(gtx, item)
+				}),
+			)
+		})
+	}, func(gtx layout.Context) layout.Dimensions {
+		// Files cannot be removed while a batch is running
+		if a.processing {
+			gtx = gtx.Disabled()
+		}
+		btn := material.Button(a.theme, &item.removeBtn, "✕")
+		btn.CornerRadius = unit.Dp(4)
+		btn.Inset = layout.Inset{
+			Top:    unit.Dp(4),
+			Bottom: unit.Dp(4),
+			Left:   unit.Dp(8),
+			Right:  unit.Dp(8),
+		}
+		return btn.Layout(gtx)
+	})
+}
+
+// layoutItemName draws the name of item with its source details, and its
+// warning or error below.
+func (a *App) layoutItemName(gtx layout.Context, item *FileItem) layout.Dimensions {
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			label := material.Body2(a.theme, item.cell(columnName))
+			label.MaxLines = 1
+			return label.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			details := item.details()
+			if details == "" {
+				return layout.Dimensions{}
+			}
+			label := material.Caption(a.theme, details)
+			label.MaxLines = 1
+			return label.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			if item.err == nil || item.status != statusFailed {
+				return layout.Dimensions{}
+			}
+			label := material.Caption(a.theme, "❌ "+item.err.Error())
+			label.Color = errorColor
+			return label.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			if item.report.Larger {
+				label := material.Caption(a.theme, "⚠ WebP is larger than the original")
+				label.Color = warningColor
+				return label.Layout(gtx)
+			}
+			if item.warning == "" {
+				return layout.Dimensions{}
+			}
+			label := material.Caption(a.theme, "⚠ "+item.warning)
+			label.Color = warningColor
+			return label.Layout(gtx)
+		}),
+	)
+}
+
+// layoutFileTable draws the header and the scrollable file rows.
+func (a *App) layoutFileTable(gtx layout.Context) layout.Dimensions {
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(4)}.Layout(gtx, a.layoutTableHeader)
+		}),
+		layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+			return material.List(a.theme, &a.list).Layout(gtx, len(a.fileItems), func(gtx layout.Context, index int) layout.Dimensions {
+				item := a.fileItems[index]
+				return layout.Inset{Bottom: unit.Dp(4)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					return a.layoutTableItem(gtx, item)
+				})
+			})
+		}),
+	)
+}
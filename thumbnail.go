@@ -0,0 +1,147 @@
+package main
+
+import (
+	"container/list"
+	"image"
+	"image/color"
+
+	"gioui.org/app"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"github.com/Sakaino2/image-compressor/controllers"
+)
+
+const (
+	// thumbnailPixels is the edge length thumbnails are decoded at, large
+	// enough to stay sharp on high density screens.
+	thumbnailPixels = 96
+
+	// thumbnailSize is the edge length thumbnails are drawn at.
+	thumbnailSize = unit.Dp(40)
+
+	// thumbnailCacheSize bounds how many decoded thumbnails are kept.
+	thumbnailCacheSize = 200
+
+	// thumbnailWorkers decode thumbnails in the background.
+	thumbnailWorkers = 2
+)
+
+// placeholderColor fills the thumbnail of a file that is not decoded yet or
+// cannot be decoded.
+var placeholderColor = color.NRGBA{R: 0xE0, G: 0xE0, B: 0xE0, A: 0xFF}
+
+// thumbnail is a decoded preview of a file.
+type thumbnail struct {
+	path string
+	img  paint.ImageOp
+	ok   bool
+}
+
+// thumbnailCache keeps the thumbnails of recently drawn files, evicting the
+// least recently used. It belongs to the UI goroutine; the workers started by
+// start decode in the background and post thumbnailEvent.
+type thumbnailCache struct {
+	entries map[string]*list.Element
+	order   *list.List
+
+	// pending holds the files queued for decoding
+	pending  map[string]bool
+	requests chan *FileItem
+}
+
+func newThumbnailCache() *thumbnailCache {
+	return &thumbnailCache{
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		pending:  make(map[string]bool),
+		requests: make(chan *FileItem, 64),
+	}
+}
+
+// start launches the decoding workers, which post to a on w.
+func (c *thumbnailCache) start(w *app.Window, a *App) {
+	for range thumbnailWorkers {
+		go func() {
+			for item := range c.requests {
+				ev := thumbnailEvent{item: item, thumb: thumbnail{path: item.path}}
+				img, width, height, err := controllers.Thumbnail(item.path, thumbnailPixels)
+				if err == nil {
+					ev.thumb.img = paint.NewImageOp(img)
+					ev.thumb.ok = true
+					ev.width, ev.height = width, height
+				}
+				a.post(w, ev)
+			}
+		}()
+	}
+}
+
+// get returns the thumbnail of item, queueing it for decoding when it is not
+// cached. A full queue is retried on a later frame instead of blocking.
+func (c *thumbnailCache) get(item *FileItem) (thumbnail, bool) {
+	if e, ok := c.entries[item.path]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(thumbnail), true
+	}
+
+	if !c.pending[item.path] {
+		select {
+		case c.requests <- item:
+			c.pending[item.path] = true
+		default:
+		}
+	}
+	return thumbnail{}, false
+}
+
+// add caches thumb, evicting the least recently used thumbnail when full.
+func (c *thumbnailCache) add(thumb thumbnail) {
+	delete(c.pending, thumb.path)
+	if e, ok := c.entries[thumb.path]; ok {
+		e.Value = thumb
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[thumb.path] = c.order.PushFront(thumb)
+	if c.order.Len() > thumbnailCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(thumbnail).path)
+	}
+}
+
+// thumbnailEvent delivers a decoded thumbnail and the dimensions of its file.
+type thumbnailEvent struct {
+	item          *FileItem
+	thumb         thumbnail
+	width, height int
+}
+
+func (e thumbnailEvent) apply(a *App) {
+	a.thumbs.add(e.thumb)
+	if e.thumb.ok {
+		e.item.width, e.item.height = e.width, e.height
+	}
+}
+
+// layoutThumbnail draws the thumbnail of item, or a placeholder until it is
+// decoded.
+func (a *App) layoutThumbnail(gtx layout.Context, item *FileItem) layout.Dimensions {
+	size := gtx.Dp(thumbnailSize)
+	gtx.Constraints = layout.Exact(image.Pt(size, size))
+
+	thumb, ok := a.thumbs.get(item)
+	if !ok || !thumb.ok {
+		paint.FillShape(gtx.Ops, placeholderColor, clip.Rect{Max: gtx.Constraints.Max}.Op())
+		return layout.Dimensions{Size: gtx.Constraints.Max}
+	}
+	return widget.Image{
+		Src:      thumb.img,
+		Fit:      widget.Contain,
+		Position: layout.Center,
+	}.Layout(gtx)
+}